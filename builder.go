@@ -0,0 +1,331 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+Typed builders for the most common object types, for code that creates config instead of reading it.
+Typos in directive names are caught by the compiler, and Build validates the rest before anything reaches
+a CfgObj, e.g.:
+
+	svc := &Service{
+		Use:                []string{"generic-service"},
+		HostName:           []string{"web01"},
+		ServiceDescription: "HTTP",
+		CheckCommand:       []string{"check_http", "-p 8080"},
+		MaxCheckAttempts:   Int(3),
+	}
+	co, err := svc.Build()
+
+Numeric and boolean directives are pointers, so that "not set" (inherit from template) can be told apart from 0.
+Directives not covered by a field, and custom variables, go in Custom.
+Builders are just structs mapped with Marshal, see marshal.go for the details.
+*/
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	HOST_NOTIFICATION_OPTS    string = "durfsn"
+	SERVICE_NOTIFICATION_OPTS string = "wucrfsn"
+	HOST_STALKING_OPTS        string = "odu"
+	SERVICE_STALKING_OPTS     string = "owuc"
+)
+
+// time ranges for timeperiod weekdays, e.g. "00:00-09:00,17:00-24:00"
+var timeRangesRX = regexp.MustCompile(`^\d\d:\d\d-\d\d:\d\d(,\s*\d\d:\d\d-\d\d:\d\d)*$`)
+
+// Builder is implemented by all the typed builders
+type Builder interface {
+	Validate() error
+	Build() (*CfgObj, error)
+}
+
+// Int returns a pointer to i, for setting numeric builder fields inline
+func Int(i int) *int {
+	return &i
+}
+
+// Bool returns a pointer to b, for setting boolean builder fields inline
+func Bool(b bool) *bool {
+	return &b
+}
+
+type Host struct {
+	Name                 string            `nagios:"name"`
+	Use                  []string          `nagios:"use"`
+	Register             *bool             `nagios:"register"`
+	HostName             string            `nagios:"host_name"`
+	Alias                string            `nagios:"alias"`
+	DisplayName          string            `nagios:"display_name"`
+	Address              string            `nagios:"address"`
+	Parents              []string          `nagios:"parents"`
+	Hostgroups           []string          `nagios:"hostgroups"`
+	CheckCommand         []string          `nagios:"check_command"`
+	MaxCheckAttempts     *int              `nagios:"max_check_attempts"`
+	CheckInterval        *int              `nagios:"check_interval"`
+	RetryInterval        *int              `nagios:"retry_interval"`
+	ActiveChecksEnabled  *bool             `nagios:"active_checks_enabled"`
+	PassiveChecksEnabled *bool             `nagios:"passive_checks_enabled"`
+	CheckPeriod          string            `nagios:"check_period"`
+	EventHandler         []string          `nagios:"event_handler"`
+	Contacts             []string          `nagios:"contacts"`
+	ContactGroups        []string          `nagios:"contact_groups"`
+	NotificationInterval *int              `nagios:"notification_interval"`
+	NotificationPeriod   string            `nagios:"notification_period"`
+	NotificationOptions  []string          `nagios:"notification_options"`
+	NotificationsEnabled *bool             `nagios:"notifications_enabled"`
+	StalkingOptions      []string          `nagios:"stalking_options"`
+	Notes                string            `nagios:"notes"`
+	NotesURL             string            `nagios:"notes_url"`
+	ActionURL            string            `nagios:"action_url"`
+	Custom               map[string]string `nagios:",remain"`
+}
+
+type Service struct {
+	Name                 string            `nagios:"name"`
+	Use                  []string          `nagios:"use"`
+	Register             *bool             `nagios:"register"`
+	HostName             []string          `nagios:"host_name"`
+	HostgroupName        []string          `nagios:"hostgroup_name"`
+	ServiceDescription   string            `nagios:"service_description"`
+	DisplayName          string            `nagios:"display_name"`
+	Servicegroups        []string          `nagios:"servicegroups"`
+	IsVolatile           *bool             `nagios:"is_volatile"`
+	CheckCommand         []string          `nagios:"check_command"`
+	MaxCheckAttempts     *int              `nagios:"max_check_attempts"`
+	CheckInterval        *int              `nagios:"check_interval"`
+	RetryInterval        *int              `nagios:"retry_interval"`
+	ActiveChecksEnabled  *bool             `nagios:"active_checks_enabled"`
+	PassiveChecksEnabled *bool             `nagios:"passive_checks_enabled"`
+	CheckPeriod          string            `nagios:"check_period"`
+	EventHandler         []string          `nagios:"event_handler"`
+	Contacts             []string          `nagios:"contacts"`
+	ContactGroups        []string          `nagios:"contact_groups"`
+	NotificationInterval *int              `nagios:"notification_interval"`
+	NotificationPeriod   string            `nagios:"notification_period"`
+	NotificationOptions  []string          `nagios:"notification_options"`
+	NotificationsEnabled *bool             `nagios:"notifications_enabled"`
+	StalkingOptions      []string          `nagios:"stalking_options"`
+	Notes                string            `nagios:"notes"`
+	NotesURL             string            `nagios:"notes_url"`
+	ActionURL            string            `nagios:"action_url"`
+	Custom               map[string]string `nagios:",remain"`
+}
+
+type Command struct {
+	CommandName string `nagios:"command_name"`
+	CommandLine string `nagios:"command_line"`
+}
+
+type Contact struct {
+	Name                        string            `nagios:"name"`
+	Use                         []string          `nagios:"use"`
+	Register                    *bool             `nagios:"register"`
+	ContactName                 string            `nagios:"contact_name"`
+	Alias                       string            `nagios:"alias"`
+	Contactgroups               []string          `nagios:"contactgroups"`
+	Email                       string            `nagios:"email"`
+	Pager                       string            `nagios:"pager"`
+	HostNotificationsEnabled    *bool             `nagios:"host_notifications_enabled"`
+	ServiceNotificationsEnabled *bool             `nagios:"service_notifications_enabled"`
+	HostNotificationPeriod      string            `nagios:"host_notification_period"`
+	ServiceNotificationPeriod   string            `nagios:"service_notification_period"`
+	HostNotificationOptions     []string          `nagios:"host_notification_options"`
+	ServiceNotificationOptions  []string          `nagios:"service_notification_options"`
+	HostNotificationCommands    []string          `nagios:"host_notification_commands"`
+	ServiceNotificationCommands []string          `nagios:"service_notification_commands"`
+	CanSubmitCommands           *bool             `nagios:"can_submit_commands"`
+	RetainStatusInformation     *bool             `nagios:"retain_status_information"`
+	RetainNonstatusInformation  *bool             `nagios:"retain_nonstatus_information"`
+	Custom                      map[string]string `nagios:",remain"`
+}
+
+type Timeperiod struct {
+	TimeperiodName string   `nagios:"timeperiod_name"`
+	Alias          string   `nagios:"alias"`
+	Monday         string   `nagios:"monday"`
+	Tuesday        string   `nagios:"tuesday"`
+	Wednesday      string   `nagios:"wednesday"`
+	Thursday       string   `nagios:"thursday"`
+	Friday         string   `nagios:"friday"`
+	Saturday       string   `nagios:"saturday"`
+	Sunday         string   `nagios:"sunday"`
+	Exclude        []string `nagios:"exclude"`
+}
+
+// isTemplate tells if an object with the given name and register value is a template, which is allowed to
+// leave out the directives otherwise required
+func isTemplate(name string, register *bool) bool {
+	return name != "" && register != nil && !*register
+}
+
+// checkOptions makes sure each option is a single letter found in valid
+func checkOptions(key string, opts []string, valid string) error {
+	for _, o := range opts {
+		if len(o) != 1 || !strings.Contains(valid, o) {
+			return fmt.Errorf("Invalid option %q for %s, valid options are %q %s", o, key, valid, dbgStr(true))
+		}
+	}
+	return nil
+}
+
+// checkCustom makes sure all keys in Custom are valid, so that Marshal doesn't get to fail on them
+func checkCustom(custom map[string]string) error {
+	for k := range custom {
+		if !IsValidProperty(k) {
+			return fmt.Errorf("Invalid property: %q %s", k, dbgStr(true))
+		}
+	}
+	return nil
+}
+
+func checkPositive(key string, i *int) error {
+	if i != nil && *i < 0 {
+		return fmt.Errorf("Value for %s can not be negative: %d %s", key, *i, dbgStr(true))
+	}
+	return nil
+}
+
+// firstError returns the first non-nil error
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// build validates b and marshals it into a new object of the given type
+func build(b Builder, ct CfgType) (*CfgObj, error) {
+	err := b.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return Marshal(b, ct)
+}
+
+// Validate checks that the host has a name, and that the given values make sense
+func (h *Host) Validate() error {
+	if h.HostName == "" && !isTemplate(h.Name, h.Register) {
+		return fmt.Errorf("Host must have host_name, or name and register 0 for a template %s", dbgStr(true))
+	}
+	return firstError(
+		checkPositive("max_check_attempts", h.MaxCheckAttempts),
+		checkPositive("check_interval", h.CheckInterval),
+		checkPositive("retry_interval", h.RetryInterval),
+		checkPositive("notification_interval", h.NotificationInterval),
+		checkOptions("notification_options", h.NotificationOptions, HOST_NOTIFICATION_OPTS),
+		checkOptions("stalking_options", h.StalkingOptions, HOST_STALKING_OPTS),
+		checkCustom(h.Custom),
+	)
+}
+
+// Build validates the host and returns it as a new CfgObj with UUID set
+func (h *Host) Build() (*CfgObj, error) {
+	return build(h, T_HOST)
+}
+
+// Validate checks that the service has a description and at least one host or hostgroup,
+// and that the given values make sense
+func (s *Service) Validate() error {
+	if !isTemplate(s.Name, s.Register) {
+		if s.ServiceDescription == "" {
+			return fmt.Errorf("Service must have service_description, or name and register 0 for a template %s", dbgStr(true))
+		}
+		if len(s.HostName) == 0 && len(s.HostgroupName) == 0 {
+			return fmt.Errorf("Service %q must have host_name or hostgroup_name %s", s.ServiceDescription, dbgStr(true))
+		}
+	}
+	return firstError(
+		checkPositive("max_check_attempts", s.MaxCheckAttempts),
+		checkPositive("check_interval", s.CheckInterval),
+		checkPositive("retry_interval", s.RetryInterval),
+		checkPositive("notification_interval", s.NotificationInterval),
+		checkOptions("notification_options", s.NotificationOptions, SERVICE_NOTIFICATION_OPTS),
+		checkOptions("stalking_options", s.StalkingOptions, SERVICE_STALKING_OPTS),
+		checkCustom(s.Custom),
+	)
+}
+
+// Build validates the service and returns it as a new CfgObj with UUID set
+func (s *Service) Build() (*CfgObj, error) {
+	return build(s, T_SERVICE)
+}
+
+// Validate checks that both command_name and command_line are set
+func (c *Command) Validate() error {
+	if c.CommandName == "" || c.CommandLine == "" {
+		return fmt.Errorf("Command must have both command_name and command_line %s", dbgStr(true))
+	}
+	if strings.Contains(c.CommandName, SEP_CMD) {
+		return fmt.Errorf("Command name %q can not contain %q %s", c.CommandName, SEP_CMD, dbgStr(true))
+	}
+	return nil
+}
+
+// Build validates the command and returns it as a new CfgObj with UUID set
+func (c *Command) Build() (*CfgObj, error) {
+	return build(c, T_COMMAND)
+}
+
+// Validate checks that the contact has a name, and that the given values make sense
+func (c *Contact) Validate() error {
+	if c.ContactName == "" && !isTemplate(c.Name, c.Register) {
+		return fmt.Errorf("Contact must have contact_name, or name and register 0 for a template %s", dbgStr(true))
+	}
+	return firstError(
+		checkOptions("host_notification_options", c.HostNotificationOptions, HOST_NOTIFICATION_OPTS),
+		checkOptions("service_notification_options", c.ServiceNotificationOptions, SERVICE_NOTIFICATION_OPTS),
+		checkCustom(c.Custom),
+	)
+}
+
+// Build validates the contact and returns it as a new CfgObj with UUID set
+func (c *Contact) Build() (*CfgObj, error) {
+	return build(c, T_CONTACT)
+}
+
+// Validate checks that the timeperiod has a name and alias, and that all weekdays have valid time ranges
+func (tp *Timeperiod) Validate() error {
+	if tp.TimeperiodName == "" || tp.Alias == "" {
+		return fmt.Errorf("Timeperiod must have both timeperiod_name and alias %s", dbgStr(true))
+	}
+	days := []struct{ key, val string }{
+		{"monday", tp.Monday},
+		{"tuesday", tp.Tuesday},
+		{"wednesday", tp.Wednesday},
+		{"thursday", tp.Thursday},
+		{"friday", tp.Friday},
+		{"saturday", tp.Saturday},
+		{"sunday", tp.Sunday},
+	}
+	for _, d := range days {
+		if d.val != "" && !timeRangesRX.MatchString(d.val) {
+			return fmt.Errorf("Invalid time range for %s: %q %s", d.key, d.val, dbgStr(true))
+		}
+	}
+	return nil
+}
+
+// Build validates the timeperiod and returns it as a new CfgObj with UUID set
+func (tp *Timeperiod) Build() (*CfgObj, error) {
+	return build(tp, T_TIMEPERIOD)
+}
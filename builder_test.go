@@ -0,0 +1,118 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServiceBuild(t *testing.T) {
+	svc := &Service{
+		Use:                 []string{"generic-service"},
+		HostName:            []string{"web01", "web02"},
+		ServiceDescription:  "HTTP",
+		CheckCommand:        []string{"check_http", "-p 8080"},
+		MaxCheckAttempts:    Int(3),
+		ActiveChecksEnabled: Bool(false),
+		NotificationOptions: []string{"w", "c", "r"},
+		Custom:              map[string]string{"_PORT": "8080"},
+	}
+	co, err := svc.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{
+		"use":                   "generic-service",
+		"host_name":             "web01,web02",
+		"service_description":   "HTTP",
+		"check_command":         "check_http!-p 8080",
+		"max_check_attempts":    "3",
+		"active_checks_enabled": "0",
+		"notification_options":  "w,c,r",
+		"_PORT":                 "8080",
+	}
+	if !reflect.DeepEqual(co.Props, exp) {
+		t.Errorf("Expected %v, got %v", exp, co.Props)
+	}
+	if co.Type != T_SERVICE || co.GetUUID() == nil {
+		t.Errorf("Expected service with UUID, got %+v", co)
+	}
+
+	bad := []*Service{
+		{HostName: []string{"web01"}},
+		{ServiceDescription: "HTTP"},
+		{HostName: []string{"web01"}, ServiceDescription: "HTTP", NotificationOptions: []string{"d"}},
+		{HostName: []string{"web01"}, ServiceDescription: "HTTP", CheckInterval: Int(-1)},
+		{HostName: []string{"web01"}, ServiceDescription: "HTTP", Custom: map[string]string{"chek_interval": "1"}},
+	}
+	for i := range bad {
+		if _, err := bad[i].Build(); err == nil {
+			t.Errorf("Expected error for %+v", bad[i])
+		}
+	}
+
+	tmpl := &Service{Name: "generic-service", Register: Bool(false), CheckInterval: Int(5)}
+	co, err = tmpl.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := co.Get("register"); v != "0" {
+		t.Errorf("Expected register 0, got %q", v)
+	}
+}
+
+func TestBuilders(t *testing.T) {
+	good := []struct {
+		b  Builder
+		ct CfgType
+	}{
+		{&Host{HostName: "web01", Address: "10.0.0.1", Parents: []string{"sw01"}}, T_HOST},
+		{&Command{CommandName: "check_http", CommandLine: "$USER1$/check_http -H $HOSTADDRESS$ $ARG1$"}, T_COMMAND},
+		{&Contact{ContactName: "ops", HostNotificationOptions: []string{"d", "r"}}, T_CONTACT},
+		{&Timeperiod{TimeperiodName: "workhours", Alias: "Work", Monday: "09:00-17:00", Tuesday: "00:00-09:00,17:00-24:00"}, T_TIMEPERIOD},
+	}
+	for _, g := range good {
+		co, err := g.b.Build()
+		if err != nil {
+			t.Errorf("Unexpected error for %+v: %s", g.b, err)
+			continue
+		}
+		if co.Type != g.ct {
+			t.Errorf("Expected %s, got %s", g.ct, co.Type)
+		}
+		if name, ok := co.GetName(); !ok || name == "" {
+			t.Errorf("Expected a name for %+v", co)
+		}
+	}
+
+	bad := []Builder{
+		&Host{Address: "10.0.0.1"},
+		&Host{Name: "generic-host"}, // template without register 0
+		&Host{HostName: "web01", NotificationOptions: []string{"w"}},
+		&Command{CommandName: "check_http"},
+		&Command{CommandName: "check!http", CommandLine: "x"},
+		&Contact{Alias: "Ops"},
+		&Timeperiod{TimeperiodName: "workhours"},
+		&Timeperiod{TimeperiodName: "workhours", Alias: "Work", Friday: "9-17"},
+	}
+	for _, b := range bad {
+		if _, err := b.Build(); err == nil {
+			t.Errorf("Expected error for %+v", b)
+		}
+	}
+}
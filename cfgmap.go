@@ -26,8 +26,23 @@ import (
 )
 
 func (cm CfgMap) SetByUUID(key UUID, val *CfgObj) bool {
-	_, exists := cm[key]
+	old, exists := cm[key]
+	if old == val {
+		cm[key] = val
+		return exists
+	}
+	obs := observersOf(cm)
+	if val != nil {
+		val = adopt(val, obs) // a copy, if val is in another observed map
+	}
 	cm[key] = val
+	if exists && old != nil {
+		release(old, obs)
+		obs.objRemoved(cm, old)
+	}
+	if val != nil {
+		obs.objAdded(cm, val)
+	}
 	return exists
 }
 
@@ -70,8 +85,12 @@ func (cm CfgMap) Get(key string) (val *CfgObj, found bool) {
 }
 
 func (cm CfgMap) DelByUUID(key UUID) *CfgObj {
-	val := cm[key]
+	val, exists := cm[key]
 	delete(cm, key)
+	if exists && val != nil {
+		release(val, observersOf(cm))
+		observersOf(cm).objRemoved(cm, val)
+	}
 	return val // might be nil
 }
 
@@ -87,11 +106,11 @@ func (cm CfgMap) SetKeys(ids UUIDs, keys, values []string) int {
 	modcnt := 0
 	if ids == nil || len(ids) == 0 {
 		for k := range cm {
-			modcnt += cm[k].SetKeys(keys, values)
+			modcnt += cm.writable(k).SetKeys(keys, values)
 		}
 	} else {
 		for i := range ids {
-			modcnt += cm[ids[i]].SetKeys(keys, values)
+			modcnt += cm.writable(ids[i]).SetKeys(keys, values)
 		}
 	}
 	return modcnt
@@ -103,7 +122,7 @@ func (cm CfgMap) DelKeys(ids UUIDs, keys []string) int {
 		return delcnt
 	} else {
 		for i := range ids {
-			delcnt += cm[ids[i]].DelKeys(keys)
+			delcnt += cm.writable(ids[i]).DelKeys(keys)
 		}
 	}
 	return delcnt
@@ -338,19 +357,41 @@ func (cm CfgMap) divertSearch(subset UUIDs, q *CfgQuery) UUIDs {
 // Given more RXs than keys, it will return all objects that match all RXs on all of the keys.
 // Given an equal amount of keys and RXs, it will return all objects that match RX on the value of the corresponding key, in given order.
 func (cm CfgMap) Search(q *CfgQuery) UUIDs {
-	if uuidorder != nil {
-		return cm.divertSearch(uuidorder, q) // this should make the search use the order given when config was read
+	if idx := indexOf(cm); idx != nil {
+		if ids, ok := idx.searchCandidates(q); ok {
+			if len(ids) == 0 {
+				return nil
+			}
+			return cm.divertSearch(ids, q)
+		}
 	}
-	return cm.divertSearch(nil, q)
+	// Keys gives the order the config was read in, with only the objects in this map, as the shared read order
+	// also holds objects from other maps, or deleted ones
+	return cm.divertSearch(cm.Keys(), q)
 }
 
 // SearchSubSet searches only the CgObjs with the given UUIDs for matches
 // Same underlying logic as for Search
 func (cm CfgMap) SearchSubSet(q *CfgQuery, ids UUIDs) UUIDs {
+	if idx := indexOf(cm); idx != nil {
+		if c, ok := idx.searchCandidates(q); ok {
+			if len(ids) > 0 {
+				ids = ids.Intersect(c)
+			} else {
+				ids = c // no subset means the whole map, as in divertSearch
+			}
+			if len(ids) == 0 {
+				return nil
+			}
+		}
+	}
 	return cm.divertSearch(ids, q)
 }
 
 func (cm CfgMap) FilterType(ts ...CfgType) UUIDs {
+	if idx := indexOf(cm); idx != nil {
+		return idx.ByType(ts...) // nil if none, as below
+	}
 	keys := cm.Keys() // do this to get objects in original order, if possible
 	matches := make(UUIDs, 0, len(keys))
 	for k := range keys {
@@ -439,48 +480,48 @@ func (cm CfgMap) SplitByFileID(sort bool) map[string]UUIDs {
 	return fmap
 }
 
+// Copy returns a deep copy of the map, where all objects are copied as well, keeping their UUIDs
+func (cm CfgMap) Copy() CfgMap {
+	c := make(CfgMap, len(cm))
+	for k, v := range cm {
+		c[k] = v.Copy()
+	}
+	return c
+}
+
 func (cm CfgMap) Len() int {
 	return len(cm)
 }
 
-// Keys tries to deliver keys in the order they were read, otherwise it's random
+// Keys tries to deliver keys in the order they were read, otherwise it's random.
+// Objects that were never read (created in code etc.) come last, in random order.
 func (cm CfgMap) Keys() UUIDs {
-	ulen := len(uuidorder)
 	clen := cm.Len()
-	keys := make(UUIDs, clen)
-	i := 0
-	if uuidorder != nil && ulen == clen { // we assume nothing has been added or deleted if length is the same (naive...)
-		copy(keys, uuidorder)
-	} else if uuidorder != nil && ulen > clen { // objects have been deleted since input was read
-		// here we just skip keys that are no longer present
-		for k := range uuidorder {
-			_, ok := cm.GetByUUID(uuidorder[k])
-			if ok {
-				keys[i] = uuidorder[k]
-				i++
-			}
-		}
-	} else { // give up and take Golangs random order
+	keys := make(UUIDs, 0, clen)
+	order := readOrder()
+	if order == nil { // give up and take Golangs random order
 		for k := range cm {
-			keys[i] = k
-			i++
+			keys = append(keys, k)
 		}
+		return keys
 	}
 
-	// Can't figure this out in a reliable manner
-	//} else if uuidorder != nil && clen > ulen { // objects have been added since input was read
-	//	for k := range cm {
-	//		idx := uuidorder.IndexOf(k)
-	//		if idx > -1 {
-	//		} else {
-	//		}
-	//	}
-	//}
-	// should still try to keep some order here, by comparing keys if uuidorder is not empty
-	//for k := range cm {
-	//	keys[i] = k
-	//	i++
-	//}
+	// uuidorder is shared by every map read in this process, and might hold deleted objects or the same
+	// UUID more than once (same JSON loaded twice), so we can't just trust the length of it
+	seen := make(map[UUID]bool, clen)
+	for _, u := range order {
+		if _, ok := cm[u]; ok && !seen[u] {
+			keys = append(keys, u)
+			seen[u] = true
+		}
+	}
+	if len(keys) < clen { // objects have been added since input was read
+		for k := range cm {
+			if !seen[k] {
+				keys = append(keys, k)
+			}
+		}
+	}
 
 	// 2017-07-24 13:02:10: We have a problem with duplicates getting printed out when saving back,
 	//  so trying to see if it's related to conversion between slices and maps
@@ -501,10 +542,7 @@ func (cm CfgMap) Keys() UUIDs {
 // mapDups searches via host_name + ; + service_description, not UUID
 func (cm CfgMap) mapDups() map[string]UUIDs {
 	dups := make(map[string]UUIDs)
-	for u := range cm {
-		if cm[u].Type != T_SERVICE {
-			continue
-		}
+	for _, u := range cm.FilterType(T_SERVICE) { // uses the index, if any
 		udesc, uok := cm[u].GetUniqueCheckName()
 		if !uok {
 			//udesc = "INVALID_ENTRY"
@@ -569,22 +607,22 @@ func (cm CfgMap) RemoveDuplicateServices(dups map[string]UUIDs) int {
 
 // json stuff
 
+// MarshalJSON writes the objects in the order given by Keys, so that order survives a round-trip
 func (cm CfgMap) MarshalJSON() ([]byte, error) {
-	mlen := cm.Len()
-	cnt := 0
+	keys := cm.Keys()
+	mlen := len(keys)
 	buf := bytes.NewBufferString("{")
-	for k, v := range cm {
+	for i, k := range keys {
 		jk, err := json.Marshal(k)
 		if err != nil {
 			return nil, err
 		}
-		jv, err := json.Marshal(v)
+		jv, err := json.Marshal(cm[k])
 		if err != nil {
 			return nil, err
 		}
 		buf.WriteString(fmt.Sprintf("%s:%s", string(jk), string(jv)))
-		cnt++
-		if cnt < mlen {
+		if i < mlen-1 {
 			buf.WriteString(",")
 		}
 	}
@@ -592,25 +630,37 @@ func (cm CfgMap) MarshalJSON() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// UnmarshalJSON reads objects into the map, registering their order of appearance just like Reader does
 func (cm CfgMap) UnmarshalJSON(b []byte) error {
-	var tmp map[string]json.RawMessage
-	err := json.Unmarshal(b, &tmp)
+	dec := json.NewDecoder(bytes.NewReader(b))
+	tok, err := dec.Token()
 	if err != nil {
 		return err
 	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("Expected JSON object, got %v %s", tok, dbgStr(true))
+	}
 
-	for k, v := range tmp {
+	for dec.More() {
+		tok, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		k, _ := tok.(string) // keys in an object are always strings
 		u, err := UUIDFromString(k)
 		if err != nil {
 			return fmt.Errorf("%s %s", err.Error(), dbgStr(true))
 		}
 		co := CfgObj{}
-		err = json.Unmarshal(v, &co)
+		err = dec.Decode(&co)
 		if err != nil {
 			return fmt.Errorf("%s %s", err.Error(), dbgStr(true))
 		}
+		co.UUID = u // the key is what the rest of the package relies on
 		cm[u] = &co
+		addOrder(u)
 	}
 
-	return nil
+	_, err = dec.Token() // closing '}'
+	return err
 }
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -43,31 +44,62 @@ func NewCfgObjWithUUID(ct CfgType) *CfgObj {
 	return o
 }
 
-// Set adds the given key/value to CfgObj.Props, returning true if the key was overwritten, and false if it was added fresh
+// Copy returns a deep copy of the object, with the same UUID
+func (co *CfgObj) Copy() *CfgObj {
+	o := *co
+	o.obs = nil // the copy is not in any map yet
+	o.frozen = false
+	o.Props = make(map[string]string, len(co.Props))
+	for k, v := range co.Props {
+		o.Props[k] = v
+	}
+	return &o
+}
+
+// Set adds the given key/value to CfgObj.Props, returning true if the key was overwritten, and false if it was added fresh.
+// Set must not be used on objects shared with a snapshot (see snapshot.go): after NagiosCfg.Snapshot, get the
+// object with NagiosCfg.Edit before changing it, or use TrySet. As false already means the key was added, Set
+// panics with ErrFrozen for frozen objects, instead of losing the change.
 func (co *CfgObj) Set(key, val string) bool {
 	if !IsValidProperty(key) {
 		return false
 	}
-	_, exists := co.Props[key]
+	if co.frozen {
+		co.refuseFrozen(key)
+	}
+	old, exists := co.Props[key]
 	co.Props[key] = val
+	if !exists || old != val {
+		co.obs.propChanged(co, key, old, val)
+	}
 	return exists // true = key was overwritten, false = key was added
 }
 
+// TrySet works like Set, but returns an error if the change is refused, as false from Set can also mean the
+// key was added. ErrFrozen is returned for frozen objects.
+func (co *CfgObj) TrySet(key, val string) (bool, error) {
+	if !IsValidProperty(key) {
+		return false, fmt.Errorf("Invalid property %q %s", key, dbgStr(true))
+	}
+	if co.frozen {
+		return false, ErrFrozen
+	}
+	return co.Set(key, val), nil
+}
+
 func (co *CfgObj) SetKeys(keys, values []string) int {
 	klen := len(keys)
 	vlen := len(values)
 	modcnt := 0
 
-	if vlen >= klen { // at least one value for each key
-		for i := range keys {
-			co.Set(keys[i], values[i])
-		}
-		modcnt = klen
-	} else { // we have more keys than values
-		for i := range values {
-			co.Set(keys[i], values[i])
+	n := klen
+	if vlen < klen { // we have more keys than values
+		n = vlen
+	}
+	for i := 0; i < n; i++ {
+		if _, err := co.TrySet(keys[i], values[i]); err == nil { // refused keys are not counted
+			modcnt++
 		}
-		modcnt = vlen
 	}
 	return modcnt
 }
@@ -75,7 +107,7 @@ func (co *CfgObj) SetKeys(keys, values []string) int {
 // Add adds the given key/value to CfgObj.Props only if the key does not already exist. Returns true if added, false otherwise.
 func (co *CfgObj) Add(key, val string) bool {
 	_, exists := co.Props[key]
-	if exists {
+	if exists || co.frozen {
 		return false
 	}
 	return !co.Set(key, val) // Set should return false, as the key doesn't exist yet, so we inverse the result
@@ -88,16 +120,38 @@ func (co *CfgObj) Get(key string) (val string, found bool) {
 }
 
 // Del deletes the entry with the given key. It returns true if anything was deleted, false otherwise.
+// Like Set, Del panics for frozen objects, see TryDel.
 func (co *CfgObj) Del(key string) bool {
-	_, exists := co.Props[key]
+	if co.frozen {
+		co.refuseFrozen(key)
+	}
+	old, exists := co.Props[key]
 	delete(co.Props, key)
+	if exists {
+		co.obs.propChanged(co, key, old, "")
+	}
 	return exists // just signals if there was anything there to be deleted in the first place
 }
 
+// TryDel works like Del, but returns ErrFrozen for frozen objects, instead of false as if there was nothing to
+// delete
+func (co *CfgObj) TryDel(key string) (bool, error) {
+	if co.frozen {
+		return false, ErrFrozen
+	}
+	return co.Del(key), nil
+}
+
+// refuseFrozen panics, for changes to a frozen object through methods that can't return an error
+func (co *CfgObj) refuseFrozen(key string) {
+	log.Errorf("Attempt to change %q in frozen object, use NagiosCfg.Edit %s", key, dbgStr(true))
+	panic(ErrFrozen)
+}
+
 func (co *CfgObj) DelKeys(keys []string) int {
 	delcnt := 0
 	for i := range keys {
-		if co.Del(keys[i]) {
+		if ok, err := co.TryDel(keys[i]); ok && err == nil {
 			delcnt++
 		}
 	}
@@ -116,6 +170,34 @@ func (co *CfgObj) LongestKey() int {
 	return max
 }
 
+// SortedKeys returns the keys of CfgObj.Props in the order they should be printed.
+// Keys with a defined position for the object's type come first, and keys sharing the same position are
+// ordered by name. All other keys (custom variables, op5 keys without a position for the type etc.) follow
+// in alphabetical order. The order is total, so no key can be left out or change place between calls.
+func (co *CfgObj) SortedKeys() []string {
+	keys := make([]string, 0, len(co.Props))
+	for k := range co.Props {
+		keys = append(keys, k)
+	}
+	co.Type.sortKeys(keys)
+	return keys
+}
+
+// sortKeys sorts keys in place, in the order described for CfgObj.SortedKeys
+func (ct CfgType) sortKeys(keys []string) {
+	sort.Slice(keys, func(i, j int) bool {
+		ii, iok := ct.SortIndex(keys[i])
+		ji, jok := ct.SortIndex(keys[j])
+		if iok != jok {
+			return iok // known keys before unknown
+		}
+		if iok && ii != ji {
+			return ii < ji
+		}
+		return keys[i] < keys[j]
+	})
+}
+
 // GetList gets a value from CfgObj.Props and returns a string slice after splitting the value on the separator given
 func (co *CfgObj) GetList(key, sep string) []string {
 	val, exists := co.Get(key)
@@ -134,7 +216,7 @@ func (co *CfgObj) SetList(key, sep string, list ...string) bool {
 // AddList does the same as SetList, but only if the key does not already exist
 func (co *CfgObj) AddList(key, sep string, list ...string) bool {
 	_, exists := co.Props[key]
-	if exists {
+	if exists || co.frozen {
 		return false
 	}
 	return !co.SetList(key, sep, list...) // SetList should return false as key does not exist, so invert the result
@@ -297,6 +379,14 @@ func (co *CfgObj) MatchSet(q *CfgQuery) bool {
 
 // generateComment is set as private, as it makes "unsafe" assumptions about the existing format of the comment
 func (co *CfgObj) generateComment() bool {
+	comment, success := co.commentFor()
+	co.Comment = comment
+	return success
+}
+
+// commentFor returns the comment generateComment would set, without changing the object, so that objects
+// can be written while others read them
+func (co *CfgObj) commentFor() (string, bool) {
 	var name string
 	var success bool
 	var is_template bool
@@ -309,14 +399,16 @@ func (co *CfgObj) generateComment() bool {
 	} else {
 		name, success = co.GetName()
 	}
-	if success && strings.Index(co.Comment, "%") > -1 {
-		if is_template {
-			co.Comment = fmt.Sprintf("# %s template '%s'", co.Type.String(), name)
-		} else {
-			co.Comment = fmt.Sprintf(co.Comment, name)
+	if !co.keepCmt && strings.Index(co.Comment, "%") > -1 {
+		if !success {
+			// objects without a name (dependencies, escalations...) would otherwise get a literal '%s' in the comment
+			return fmt.Sprintf("# %s", co.Type.String()), success
+		} else if is_template {
+			return fmt.Sprintf("# %s template '%s'", co.Type.String(), name), success
 		}
+		return fmt.Sprintf(co.Comment, name), success
 	}
-	return success
+	return co.Comment, success
 }
 
 // AutoAlign sets the CfgObj alignment/spacing to LongestKey + 2
@@ -329,20 +421,24 @@ func (co *CfgObj) AutoAlign() int {
 
 func (co *CfgObj) MarshalJSON() ([]byte, error) {
 	// First attempt based on https://gist.github.com/mdwhatcott/8dd2eef0042f7f1c0cd8
+	// Fields and props are written in a fixed order, to get stable output that diffs well
 	buf := bytes.NewBufferString("{")
 
-	var pmap = map[string]interface{}{
-		"uuid":   co.UUID.String(),
-		"fileid": co.FileID,
-		"type":   co.Type,
+	var fields = []struct {
+		k string
+		v interface{}
+	}{
+		{"uuid", co.UUID.String()},
+		{"fileid", co.FileID},
+		{"type", co.Type.String()}, // by name, so documents don't break if CfgType is renumbered
 	}
 
-	for k, v := range pmap {
-		jk, err := json.Marshal(k)
+	for _, f := range fields {
+		jk, err := json.Marshal(f.k)
 		if err != nil {
 			return nil, err
 		}
-		jv, err := json.Marshal(v)
+		jv, err := json.Marshal(f.v)
 		if err != nil {
 			return nil, err
 		}
@@ -357,20 +453,19 @@ func (co *CfgObj) MarshalJSON() ([]byte, error) {
 	buf.WriteString(fmt.Sprintf("%s:{", string(prop_k)))
 
 	// loop through props
-	plen := len(co.Props)
-	cnt := 0
-	for k, v := range co.Props {
+	keys := co.SortedKeys()
+	plen := len(keys)
+	for i, k := range keys {
 		jkey, err := json.Marshal(k)
 		if err != nil {
 			return nil, err
 		}
-		jval, err := json.Marshal(v)
+		jval, err := json.Marshal(co.Props[k])
 		if err != nil {
 			return nil, err
 		}
 		buf.WriteString(fmt.Sprintf("%s:%s", string(jkey), string(jval)))
-		cnt++
-		if cnt < plen {
+		if i < plen-1 {
 			buf.WriteString(",")
 		}
 	}
@@ -386,11 +481,19 @@ func (co *CfgObj) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
-	objtype, found := tmp["type"].(float64)
-	if !found {
-		return fmt.Errorf("Unabble to parse object type %s", dbgStr(true))
+	var objtype CfgType
+	switch t := tmp["type"].(type) {
+	case string:
+		objtype = CfgName(t).Type()
+	case float64: // documents written before the type was stored by name
+		objtype = CfgType(t)
+	default:
+		return fmt.Errorf("Unable to parse object type %s", dbgStr(true))
+	}
+	if !objtype.Valid() {
+		return fmt.Errorf("Invalid object type: %v %s", tmp["type"], dbgStr(true))
 	}
-	obj := NewCfgObj(CfgType(objtype))
+	obj := NewCfgObj(objtype)
 	fileid, found := tmp["fileid"].(string)
 	if found {
 		obj.FileID = fileid
@@ -412,7 +515,11 @@ func (co *CfgObj) UnmarshalJSON(b []byte) error {
 		return fmt.Errorf("Unable to parse object properties %s", dbgStr(true))
 	}
 	for k, v := range props {
-		obj.Add(k, v.(string))
+		sv, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("Value for %q is not a string %s", k, dbgStr(true))
+		}
+		obj.Add(k, sv)
 	}
 
 	*co = *obj
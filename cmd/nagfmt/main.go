@@ -0,0 +1,213 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+nagfmt formats Nagios object configuration files, the same way gofmt does for Go source.
+
+Without any flags, the formatted config is written to stdout. Without any paths, nagfmt
+works as a filter from stdin to stdout. Directories are walked recursively for *.cfg files.
+
+Exit status is 0 if all went well, 1 if -l or -d found files that are not formatted, and 2
+on errors, so that "nagfmt -l" can be used directly as a pre-commit hook.
+
+Comment lines before each definition, and at the end of a file, are kept as they are.
+Comments inside a definition are moved before it, and objects without a comment get a
+generated one.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/vgtmnm/nagioscfg"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var BUILD_DATE string
+
+var (
+	list    = flag.Bool("l", false, "list files whose formatting differs from nagfmt's")
+	write   = flag.Bool("w", false, "write result to (source) file instead of stdout")
+	doDiff  = flag.Bool("d", false, "display diffs instead of rewriting files")
+	version = flag.Bool("V", false, "print version and exit")
+)
+
+const (
+	E_OK = iota
+	E_UNFORMATTED
+	E_ERROR
+)
+
+var exitCode = E_OK
+
+func report(err error) {
+	fmt.Fprintf(os.Stderr, "%s\n", err)
+	exitCode = E_ERROR
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: nagfmt [flags] [path ...]\n")
+	flag.PrintDefaults()
+}
+
+func isCfgFile(f os.FileInfo) bool {
+	name := f.Name()
+	return !f.IsDir() && !strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".cfg")
+}
+
+func processFile(filename string, in io.Reader, out io.Writer) error {
+	if in == nil {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	res, err := nagioscfg.Format(src)
+	if err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+
+	if bytes.Equal(src, res) {
+		if !*list && !*doDiff && !*write {
+			_, err = out.Write(res)
+		}
+		return err
+	}
+
+	// formatting differs
+	if (*list || *doDiff) && exitCode == E_OK {
+		exitCode = E_UNFORMATTED
+	}
+	if *list {
+		fmt.Fprintln(out, filename)
+	}
+	if *write {
+		fi, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filename, res, fi.Mode().Perm())
+		if err != nil {
+			return err
+		}
+	}
+	if *doDiff {
+		data, err := diff(src, res)
+		if err != nil {
+			return fmt.Errorf("computing diff: %s", err)
+		}
+		fmt.Fprintf(out, "diff %s nagfmt/%s\n", filename, filename)
+		out.Write(data)
+	}
+	if !*list && !*write && !*doDiff {
+		_, err = out.Write(res)
+	}
+
+	return err
+}
+
+func visitFile(path string, f os.FileInfo, err error) error {
+	if err == nil && isCfgFile(f) {
+		err = processFile(path, nil, os.Stdout)
+	}
+	if err != nil {
+		report(err)
+	}
+	return nil
+}
+
+func walkDir(path string) {
+	filepath.Walk(path, visitFile)
+}
+
+func diff(b1, b2 []byte) ([]byte, error) {
+	f1, err := ioutil.TempFile("", "nagfmt")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f1.Name())
+	defer f1.Close()
+
+	f2, err := ioutil.TempFile("", "nagfmt")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f2.Name())
+	defer f2.Close()
+
+	f1.Write(b1)
+	f2.Write(b2)
+
+	data, err := exec.Command("diff", "-u", f1.Name(), f2.Name()).CombinedOutput()
+	if len(data) > 0 {
+		// diff exits with a non-zero status when the files don't match.
+		// Ignore that failure as long as we get output.
+		err = nil
+	}
+	return data, err
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("nagfmt (%s %s) built %s\n", nagioscfg.PKGNAME, nagioscfg.VERSION, BUILD_DATE)
+		return
+	}
+
+	if flag.NArg() == 0 {
+		if *write {
+			report(fmt.Errorf("error: cannot use -w with standard input"))
+			os.Exit(exitCode)
+		}
+		if err := processFile("<standard input>", os.Stdin, os.Stdout); err != nil {
+			report(err)
+		}
+		os.Exit(exitCode)
+	}
+
+	for i := 0; i < flag.NArg(); i++ {
+		path := flag.Arg(i)
+		switch dir, err := os.Stat(path); {
+		case err != nil:
+			report(err)
+		case dir.IsDir():
+			walkDir(path)
+		default:
+			if err := processFile(path, nil, os.Stdout); err != nil {
+				report(err)
+			}
+		}
+	}
+
+	os.Exit(exitCode)
+}
@@ -0,0 +1,166 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+nagmerge does a three-way merge of Nagios object configuration files, object by object instead of line by line.
+
+	nagmerge [flags] base ours theirs
+
+The result is written over ours, unless -o is given. Conflicts are printed to stderr, and resolved in favour
+of ours in the result, so there are never any conflict markers to break the config.
+
+Exit status is 0 for a clean merge, 1 if there were conflicts, and 2 on errors. This is what git expects
+from a merge driver, so to use it for all *.cfg files, add this to .git/config (or ~/.gitconfig):
+
+	[merge "nagios"]
+		name = Nagios object merge
+		driver = nagmerge -p %P %O %A %B
+
+and this to .gitattributes:
+
+	*.cfg merge=nagios
+
+Comments before each definition are kept with the object, and merged like a property, without conflicts.
+Comments at the end of ours are kept at the end of the result.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/vgtmnm/nagioscfg"
+	"os"
+)
+
+var BUILD_DATE string
+
+var (
+	output   = flag.String("o", "", "write result to this file instead of over ours")
+	pathName = flag.String("p", "", "path name to use in conflict messages (%P from git)")
+	asJSON   = flag.Bool("j", false, "print conflicts as JSON")
+	version  = flag.Bool("V", false, "print version and exit")
+)
+
+const (
+	E_OK = iota
+	E_CONFLICT
+	E_ERROR
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: nagmerge [flags] base ours theirs\n")
+	flag.PrintDefaults()
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "nagmerge: %s\n", err)
+	os.Exit(E_ERROR)
+}
+
+// load reads all objects in filename, and returns them with the comment lines after the last one
+func load(filename string) (nagioscfg.CfgMap, []string, error) {
+	fr := nagioscfg.NewFileReader(filename)
+	if fr == nil {
+		return nil, nil, fmt.Errorf("unable to open %q", filename)
+	}
+	defer fr.Close()
+	cm, err := fr.ReadAllMap(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return cm, fr.Comments(), nil
+}
+
+func printConflicts(conflicts []*nagioscfg.MergeConflict) error {
+	if *asJSON {
+		b, err := json.Marshal(conflicts)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(os.Stderr, "%s\n", b)
+		return err
+	}
+	for _, c := range conflicts {
+		if *pathName != "" {
+			fmt.Fprintf(os.Stderr, "%s: ", *pathName)
+		}
+		err := c.Print(os.Stderr)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("nagmerge (%s %s) built %s\n", nagioscfg.PKGNAME, nagioscfg.VERSION, BUILD_DATE)
+		return
+	}
+	if flag.NArg() != 3 {
+		usage()
+		os.Exit(E_ERROR)
+	}
+
+	// load in this order, so that the result keeps the order of ours, with additions from theirs last
+	var cms [3]nagioscfg.CfgMap
+	var trailing []string
+	for i, fname := range flag.Args() {
+		cm, comments, err := load(fname)
+		if err != nil {
+			fatal(err)
+		}
+		cms[i] = cm
+		if i == 1 {
+			trailing = comments
+		}
+	}
+
+	res, conflicts := nagioscfg.Merge3(cms[0], cms[1], cms[2])
+
+	outfile := *output
+	if outfile == "" {
+		outfile = flag.Arg(1)
+	}
+	f, err := os.Create(outfile)
+	if err != nil {
+		fatal(err)
+	}
+	err = res.Print(f, true)
+	for i := 0; err == nil && i < len(trailing); i++ {
+		_, err = fmt.Fprintln(f, trailing[i])
+	}
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	if len(conflicts) > 0 {
+		err = printConflicts(conflicts)
+		if err != nil {
+			fatal(err)
+		}
+		os.Exit(E_CONFLICT)
+	}
+}
@@ -0,0 +1,374 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+/*
+ncfgjson converts Nagios object configuration to JSON, and back.
+
+Input is read from the files given as arguments, or from stdin if there are none.
+Output goes to stdout, unless -w is given when converting back to Nagios format, in which
+case each object is written back to the file given by its FileID. As each file is rewritten
+whole, -w can not be combined with -t, -q or -e.
+
+Examples:
+	ncfgjson -p /etc/nagios/services.cfg > services.json
+	ncfgjson -t service -q host_name='^web' < services.cfg
+	ncfgjson -e 'type=service AND host_name=~^web AND NOT check_command=~ping' services.cfg
+	ncfgjson -r services.json
+	ncfgjson -r -w services.json
+	ncfgjson -n services.cfg | jq .props.host_name
+	ncfgjson -t host -T host_name,address -S address hosts.cfg
+	ncfgjson -t service -g host_name services.cfg
+
+With -n, objects are streamed as JSON Lines, one object per line, without reading the whole
+config into memory first.
+
+With -T, -g or -u, the selected objects are printed as a table instead: the given columns for
+each object (sorted by -S), the number of objects per value of a key, or the distinct values of
+a key. Lists are split for -g, so that services for more than one host are counted for each.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/vgtmnm/nagioscfg"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var BUILD_DATE string
+
+// queryFlag collects repeated -q key=regex arguments into a CfgQuery
+type queryFlag struct {
+	q *nagioscfg.CfgQuery
+}
+
+func (qf *queryFlag) String() string {
+	if qf.q == nil {
+		return ""
+	}
+	s := make([]string, len(qf.q.Keys))
+	for i := range qf.q.Keys {
+		s[i] = fmt.Sprintf("%s=%s", qf.q.Keys[i], qf.q.RXs[i])
+	}
+	return strings.Join(s, " ")
+}
+
+func (qf *queryFlag) Set(val string) error {
+	kv := strings.SplitN(val, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("expected key=regex, got %q", val)
+	}
+	if qf.q == nil {
+		qf.q = nagioscfg.NewCfgQuery()
+	}
+	if !qf.q.AddKeyRX(kv[0], kv[1]) {
+		return fmt.Errorf("invalid key or regex in %q", val)
+	}
+	return nil
+}
+
+var (
+	reverse = flag.Bool("r", false, "reverse: convert JSON to Nagios format")
+	pretty  = flag.Bool("p", false, "pretty print JSON output (default is compact)")
+	lines   = flag.Bool("n", false, "JSON Lines (NDJSON): one object per line, streamed as read")
+	write   = flag.Bool("w", false, "with -r: write objects back to the files given by their FileID, instead of stdout")
+	sorted  = flag.Bool("s", true, "with -r: print object properties in canonical order")
+	types   = flag.String("t", "", "only include objects of the given type(s), comma separated")
+	expr    = flag.String("e", "", "only include objects matching the query expression, e.g. 'type=host AND NOT EXISTS parents'")
+	inherit = flag.Bool("i", false, "with -e: match against template resolved values, including inherited ones")
+	columns = flag.String("T", "", "print a table with the given columns, comma separated, instead of JSON")
+	sortBy  = flag.String("S", "", "with -T: sort rows by the given keys, comma separated, \"-\" prefix for descending")
+	groupBy = flag.String("g", "", "print the number of objects per value of the given key, instead of JSON")
+	uniq    = flag.String("u", "", "print the distinct values of the given key, instead of JSON")
+	debug   = flag.Bool("debug", false, "enable debug logging")
+	version = flag.Bool("V", false, "print version and exit")
+	query   queryFlag
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: ncfgjson [flags] [file ...]\n")
+	flag.PrintDefaults()
+}
+
+func parseTypes(s string) ([]nagioscfg.CfgType, error) {
+	if s == "" {
+		return nil, nil
+	}
+	names := strings.Split(s, nagioscfg.SEP_LST)
+	ts := make([]nagioscfg.CfgType, 0, len(names))
+	for _, n := range names {
+		cn := nagioscfg.CfgName(strings.TrimSpace(n))
+		if !cn.Valid() {
+			return nil, fmt.Errorf("invalid type %q, valid types are: %s", cn, strings.Join(nagioscfg.ValidCfgNames(), ", "))
+		}
+		ts = append(ts, cn.Type())
+	}
+	return ts, nil
+}
+
+// selection holds what is given by -t, -q and -e. Nil fields don't filter anything.
+type selection struct {
+	ts []nagioscfg.CfgType
+	q  *nagioscfg.CfgQuery
+	e  *nagioscfg.Query
+}
+
+func (sel *selection) match(o *nagioscfg.CfgObj) bool {
+	return (sel.ts == nil || o.Type.In(sel.ts)) && (sel.q == nil || o.MatchSet(sel.q)) && (sel.e == nil || sel.e.Match(o))
+}
+
+// filter returns the objects selected by -t, -q and -e, in original order
+func filter(nc *nagioscfg.NagiosCfg, sel *selection) nagioscfg.CfgMap {
+	ts, q := sel.ts, sel.q
+	if ts == nil && q == nil && sel.e == nil {
+		return nc.Config
+	}
+	if ts != nil {
+		if nc.FilterType(ts...).Empty() {
+			return make(nagioscfg.CfgMap) // or else Search below would start over from the whole config
+		}
+	}
+	if q != nil {
+		if nc.Search(q).Empty() {
+			return make(nagioscfg.CfgMap)
+		}
+	}
+	if sel.e != nil {
+		if *inherit {
+			nc.SearchResolved(sel.e)
+		} else {
+			nc.SearchQuery(sel.e)
+		}
+	}
+	cm := make(nagioscfg.CfgMap)
+	for _, u := range nc.GetMatches() {
+		cm[u] = nc.Config[u]
+	}
+	return cm
+}
+
+func loadNagios(files []string) (*nagioscfg.NagiosCfg, error) {
+	nc := nagioscfg.NewNagiosCfg()
+	if len(files) == 0 {
+		return nc, nc.LoadStdin()
+	}
+	return nc, nc.LoadFiles(files...)
+}
+
+func loadJSON(files []string) (*nagioscfg.NagiosCfg, error) {
+	readDoc := func(r io.Reader) (*nagioscfg.NagiosCfg, error) {
+		if *lines {
+			nc := nagioscfg.NewNagiosCfg()
+			cm, err := nagioscfg.NewJSONLinesDecoder(r).DecodeMap()
+			nc.Config = cm
+			return nc, err
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		nc := &nagioscfg.NagiosCfg{}
+		return nc, json.Unmarshal(b, nc)
+	}
+
+	if len(files) == 0 {
+		return readDoc(os.Stdin)
+	}
+
+	var nc *nagioscfg.NagiosCfg
+	for _, fname := range files {
+		f, err := os.Open(fname)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := readDoc(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", fname, err)
+		}
+		if nc == nil {
+			nc = doc
+			continue
+		}
+		if err := nc.Config.Append(doc.Config); err != nil {
+			return nil, fmt.Errorf("%s: %s", fname, err)
+		}
+	}
+	return nc, nil
+}
+
+// streamJSONLines writes each object as it is read, filtered one by one instead of via NagiosCfg
+func streamJSONLines(files []string, sel *selection) error {
+	enc := nagioscfg.NewJSONLinesEncoder(os.Stdout)
+	stream := func(in <-chan *nagioscfg.CfgObj) error {
+		out := make(chan *nagioscfg.CfgObj)
+		go func() {
+			for o := range in {
+				if sel.match(o) {
+					out <- o
+				}
+			}
+			close(out)
+		}()
+		_, err := enc.EncodeChan(out)
+		return err
+	}
+
+	if len(files) == 0 {
+		return stream(nagioscfg.NewReader(os.Stdin).ReadChan(true, ""))
+	}
+	// one file at a time, to keep the order of objects
+	for _, fname := range files {
+		fr := nagioscfg.NewFileReader(fname)
+		if fr == nil {
+			return fmt.Errorf("unable to open %q", fname)
+		}
+		fileID, err := fr.AbsPath()
+		if err != nil {
+			fileID = fname
+		}
+		err = stream(fr.ReadChan(true, fileID))
+		fr.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tabular returns true if the output should be a table instead of objects
+func tabular() bool {
+	return *columns != "" || *groupBy != "" || *uniq != ""
+}
+
+// printTable prints the objects as given by -T, -S, -g or -u
+func printTable(cm nagioscfg.CfgMap) error {
+	cos := cm.Objs(cm.Keys())
+	switch {
+	case *groupBy != "":
+		return nagioscfg.PrintGroups(os.Stdout, *groupBy, cos.GroupByEach(*groupBy))
+	case *uniq != "":
+		for _, v := range cos.Distinct(*uniq) {
+			fmt.Println(v)
+		}
+		return nil
+	}
+	if *sortBy != "" {
+		cos.SortBy(strings.Split(*sortBy, nagioscfg.SEP_LST)...)
+	}
+	return cos.PrintTable(os.Stdout, strings.Split(*columns, nagioscfg.SEP_LST)...)
+}
+
+func toJSON(files []string, sel *selection) error {
+	if *lines {
+		return streamJSONLines(files, sel)
+	}
+	nc, err := loadNagios(files)
+	if err != nil {
+		return err
+	}
+	if tabular() {
+		return printTable(filter(nc, sel))
+	}
+	out := &nagioscfg.NagiosCfg{
+		SessionID: nc.SessionID,
+		Config:    filter(nc, sel),
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	if *pretty {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, b, "", "  "); err != nil {
+			return err
+		}
+		b = buf.Bytes()
+	}
+	b = append(b, '\n')
+	_, err = os.Stdout.Write(b)
+	return err
+}
+
+func fromJSON(files []string, sel *selection) error {
+	nc, err := loadJSON(files)
+	if err != nil {
+		return err
+	}
+	cm := filter(nc, sel)
+	if tabular() {
+		return printTable(cm)
+	}
+	if *write {
+		return cm.WriteByFileID(*sorted)
+	}
+	return cm.Print(os.Stdout, *sorted)
+}
+
+func main() {
+	flag.Var(&query, "q", "only include objects where key matches regex, given as key=regex (repeatable)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *version {
+		fmt.Printf("ncfgjson (%s %s) built %s\n", nagioscfg.PKGNAME, nagioscfg.VERSION, BUILD_DATE)
+		return
+	}
+	if *debug {
+		log.SetLevel(log.DebugLevel)
+	}
+	if *write && !*reverse {
+		log.Fatal("-w can only be used together with -r")
+	}
+	if *write && (*types != "" || query.q != nil || *expr != "") {
+		// files are rewritten whole, so a selection would drop everything not selected from them
+		log.Fatal("-w can not be used together with -t, -q or -e")
+	}
+	if tabular() && *lines && !*reverse {
+		log.Fatal("-T, -g and -u need the whole config, and can not be used with -n")
+	}
+	if *inherit && *lines && !*reverse {
+		log.Fatal("-i needs the whole config to resolve templates, and can not be used with -n")
+	}
+
+	ts, err := parseTypes(*types)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sel := &selection{ts: ts, q: query.q}
+	if *expr != "" {
+		sel.e, err = nagioscfg.ParseQuery(*expr)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *reverse {
+		err = fromJSON(flag.Args(), sel)
+	} else {
+		err = toJSON(flag.Args(), sel)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -626,6 +626,7 @@ var CfgKeySortOrder = map[string]map[CfgType]int{
 		T_TIMEPERIOD:        2,
 	},
 	CfgKeys[87]: map[CfgType]int{ // timeperiod_name
+		T_TIMEPERIOD: 0,
 	},
 	CfgKeys[88]: map[CfgType]int{ // tuesday
 		T_SERVICEDEPENDENCY: 99, // value outside defined range, will not be used, only here for alignment
@@ -665,13 +666,13 @@ var CfgKeySortOrder = map[string]map[CfgType]int{
 		T_SERVICE:           42,
 		T_SERVICEDEPENDENCY: 99, // value outside defined range, will not be used, only here for alignment
 	},
-	CfgKeys[95]: map[CfgType]int{ // hourly_value
+	CfgKeys[96]: map[CfgType]int{ // hourly_value
 		T_SERVICE:           44,
 		T_SERVICEDEPENDENCY: 99, // value outside defined range, will not be used, only here for alignment
 	},
 }
 
-var uuidorder UUIDs // append to this every time an object is read
+var uuidorder UUIDs // append to this every time an object is read, with addOrder
 
 type CfgObj struct {
 	Type    CfgType           `json:"-"`
@@ -681,6 +682,9 @@ type CfgObj struct {
 	FileID  string            `json:"fileid"`
 	Comment string            `json:"-"`
 	Props   map[string]string `json:"props"`
+	obs     *observerSet      // set while the object is in a map with observers, see hooks.go
+	frozen  bool              // shared with a snapshot, and must be copied before changing, see snapshot.go
+	keepCmt bool              // Comment was read from the input, and is written back as it is
 }
 
 type CfgQuery struct {
@@ -690,11 +694,16 @@ type CfgQuery struct {
 
 // Top level struct for managing collections of CfgObj
 type NagiosCfg struct {
-	SessionID UUID
-	Config    CfgMap // the full config
-	pipe      bool   // indicator of whether the content came from stdin and should be written to stdout or not
-	matches   UUIDs  // subset of config
-	inorder   UUIDs  // uuids ordered by how they were read in
+	SessionID  UUID
+	Config     CfgMap // the full config
+	pipe       bool   // indicator of whether the content came from stdin and should be written to stdout or not
+	matches    UUIDs  // subset of config
+	inorder    UUIDs  // uuids ordered by how they were read in
+	journal    *Journal
+	index      *Index
+	selections map[string]UUIDs // named selections, see selection.go
+	rels       *relCache        // host relations, see lookup.go
+	owner      *hookOwner       // closes the journal, index and rels when the config is gone, see hooks.go
 }
 
 //type GenericReader interface {
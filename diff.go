@@ -0,0 +1,269 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+Semantic diff between two configurations.
+UUIDs are regenerated on every load, so objects are matched by what they are known as in Nagios instead,
+see CfgObj.Identity. Objects without an identity are matched on all their properties, so for those a change
+shows up as one object removed and another added.
+If more than one object of a type has the same name, they are matched in the order they were read.
+
+CfgDiff.Print gives output like this, removed objects first, then modified and added:
+
+	- command check_old
+	    - command_line $USER1$/check_old
+	~ host web01
+	    ~ address 10.0.0.1 => 10.0.0.2
+	    + notes new host
+	+ service web01;HTTP
+	    + host_name web01
+	    ...
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type DiffKind int
+
+const (
+	DIFF_ADDED DiffKind = iota
+	DIFF_REMOVED
+	DIFF_MODIFIED
+)
+
+var diffKindNames = [...]string{
+	"added",
+	"removed",
+	"modified",
+}
+
+var diffKindSigns = [...]string{
+	"+",
+	"-",
+	"~",
+}
+
+// PropChange is a change to a single property. Old is empty if the property was added, and New is empty if
+// it was removed, as Nagios has no such thing as an empty value.
+type PropChange struct {
+	Key string `json:"key"`
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+// ObjDiff describes an object that differs between two configurations.
+// For added and removed objects, Changes lists all properties.
+type ObjDiff struct {
+	Kind    DiffKind
+	Type    CfgType
+	ID      string  // the name the object was matched on
+	A       *CfgObj // the object in the old config, nil if added
+	B       *CfgObj // the object in the new config, nil if removed
+	Changes []PropChange
+}
+
+// CfgDiff holds all differences between two configurations
+type CfgDiff struct {
+	Added    []*ObjDiff `json:"added"`
+	Removed  []*ObjDiff `json:"removed"`
+	Modified []*ObjDiff `json:"modified"`
+}
+
+func (dk DiffKind) String() string {
+	return diffKindNames[dk]
+}
+
+// diffID returns the ID used to match objects between configs
+func diffID(co *CfgObj) string {
+	name, ok := co.Identity()
+	if ok {
+		return name
+	}
+	keys := co.SortedKeys()
+	kv := make([]string, len(keys))
+	for i, k := range keys {
+		kv[i] = k + "=" + co.Props[k]
+	}
+	return "{" + strings.Join(kv, ";") + "}"
+}
+
+// diffIndex maps type + ID to objects, in original order
+func diffIndex(cm CfgMap) (map[string]CfgObjs, []string) {
+	idx := make(map[string]CfgObjs)
+	order := make([]string, 0, len(cm))
+	for _, k := range cm.Keys() {
+		co := cm[k]
+		key := co.Type.String() + "/" + diffID(co)
+		if _, found := idx[key]; !found {
+			order = append(order, key)
+		}
+		idx[key] = append(idx[key], co)
+	}
+	return idx, order
+}
+
+// propChanges compares the properties of a and b. Either can be nil.
+func propChanges(ct CfgType, a, b *CfgObj) []PropChange {
+	var ap, bp map[string]string
+	if a != nil {
+		ap = a.Props
+	}
+	if b != nil {
+		bp = b.Props
+	}
+	keys := make([]string, 0, len(ap)+len(bp))
+	for k := range ap {
+		keys = append(keys, k)
+	}
+	for k := range bp {
+		if _, found := ap[k]; !found {
+			keys = append(keys, k)
+		}
+	}
+	ct.sortKeys(keys)
+
+	var changes []PropChange
+	for _, k := range keys {
+		if ap[k] != bp[k] {
+			changes = append(changes, PropChange{Key: k, Old: ap[k], New: bp[k]})
+		}
+	}
+	return changes
+}
+
+// Diff returns the differences between the configs a and b, with b being the newer one
+func Diff(a, b *NagiosCfg) *CfgDiff {
+	return DiffMaps(a.Config, b.Config)
+}
+
+// DiffMaps returns the differences between the objects in a and b, with b being the newer one.
+// Removed and modified objects are listed in the order of a, added objects in the order of b.
+func DiffMaps(a, b CfgMap) *CfgDiff {
+	d := &CfgDiff{
+		Added:    []*ObjDiff{},
+		Removed:  []*ObjDiff{},
+		Modified: []*ObjDiff{},
+	}
+	aidx, aorder := diffIndex(a)
+	bidx, border := diffIndex(b)
+
+	for _, key := range aorder {
+		as, bs := aidx[key], bidx[key]
+		for i, ao := range as {
+			id := diffID(ao)
+			if i >= len(bs) {
+				d.Removed = append(d.Removed, &ObjDiff{
+					Kind:    DIFF_REMOVED,
+					Type:    ao.Type,
+					ID:      id,
+					A:       ao,
+					Changes: propChanges(ao.Type, ao, nil),
+				})
+				continue
+			}
+			changes := propChanges(ao.Type, ao, bs[i])
+			if len(changes) > 0 {
+				d.Modified = append(d.Modified, &ObjDiff{
+					Kind:    DIFF_MODIFIED,
+					Type:    ao.Type,
+					ID:      id,
+					A:       ao,
+					B:       bs[i],
+					Changes: changes,
+				})
+			}
+		}
+	}
+	for _, key := range border {
+		as, bs := aidx[key], bidx[key]
+		for i := len(as); i < len(bs); i++ {
+			d.Added = append(d.Added, &ObjDiff{
+				Kind:    DIFF_ADDED,
+				Type:    bs[i].Type,
+				ID:      diffID(bs[i]),
+				B:       bs[i],
+				Changes: propChanges(bs[i].Type, nil, bs[i]),
+			})
+		}
+	}
+	return d
+}
+
+// Empty returns true if there are no differences
+func (d *CfgDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// Len returns the number of objects that differ
+func (d *CfgDiff) Len() int {
+	return len(d.Added) + len(d.Removed) + len(d.Modified)
+}
+
+// Print writes the diff in a human readable format, see the top of this file
+func (d *CfgDiff) Print(w io.Writer) error {
+	for _, lst := range [][]*ObjDiff{d.Removed, d.Modified, d.Added} {
+		for _, od := range lst {
+			err := od.Print(w)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Print writes a single object diff, in the format described for CfgDiff.Print
+func (od *ObjDiff) Print(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%s %s %s\n", diffKindSigns[od.Kind], od.Type.String(), od.ID)
+	if err != nil {
+		return err
+	}
+	for _, pc := range od.Changes {
+		switch {
+		case pc.Old == "":
+			_, err = fmt.Fprintf(w, "    + %s %s\n", pc.Key, pc.New)
+		case pc.New == "":
+			_, err = fmt.Fprintf(w, "    - %s %s\n", pc.Key, pc.Old)
+		default:
+			_, err = fmt.Fprintf(w, "    ~ %s %s => %s\n", pc.Key, pc.Old, pc.New)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (od *ObjDiff) MarshalJSON() ([]byte, error) {
+	tmp := struct {
+		Kind    string       `json:"kind"`
+		Type    string       `json:"type"`
+		ID      string       `json:"id"`
+		Changes []PropChange `json:"changes"`
+	}{
+		Kind:    od.Kind.String(),
+		Type:    od.Type.String(),
+		ID:      od.ID,
+		Changes: od.Changes,
+	}
+	return json.Marshal(tmp)
+}
@@ -0,0 +1,125 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var diffcfgstr_a string = `define host{
+	host_name web01
+	address 10.0.0.1
+	alias Web 1
+}
+define command{
+	command_name check_old
+	command_line $USER1$/check_old
+}
+define service{
+	host_name web01
+	service_description HTTP
+	check_command check_http
+}
+define hostdependency{
+	host_name web01
+	dependent_host_name web02
+}
+`
+
+var diffcfgstr_b string = `define service{
+	host_name web01
+	service_description HTTP
+	check_command check_http
+}
+define host{
+	host_name web01
+	address 10.0.0.2
+	notes new host
+}
+define service{
+	host_name web01
+	service_description HTTPS
+	check_command check_https
+}
+define hostdependency{
+	host_name web01
+	dependent_host_name web02
+}
+`
+
+func loadDiffCfg(t *testing.T, s string) *NagiosCfg {
+	nc := NewNagiosCfg()
+	cm, err := NewReader(strings.NewReader(s)).ReadAllMap("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc.Config = cm
+	return nc
+}
+
+func TestDiff(t *testing.T) {
+	a := loadDiffCfg(t, diffcfgstr_a)
+	b := loadDiffCfg(t, diffcfgstr_b)
+
+	d := Diff(a, b)
+	if len(d.Added) != 1 || len(d.Removed) != 1 || len(d.Modified) != 1 {
+		t.Fatalf("Expected 1 added, removed and modified, got %d, %d and %d", len(d.Added), len(d.Removed), len(d.Modified))
+	}
+	if d.Added[0].ID != "web01;HTTPS" || d.Removed[0].ID != "check_old" {
+		t.Errorf("Wrong objects added/removed: %q %q", d.Added[0].ID, d.Removed[0].ID)
+	}
+	exp := []PropChange{
+		{Key: "alias", Old: "Web 1"},
+		{Key: "address", Old: "10.0.0.1", New: "10.0.0.2"},
+		{Key: "notes", New: "new host"},
+	}
+	mod := d.Modified[0]
+	if mod.Type != T_HOST || !reflect.DeepEqual(mod.Changes, exp) {
+		t.Errorf("Expected %+v, got %+v", exp, mod.Changes)
+	}
+
+	var buf bytes.Buffer
+	err := d.Print(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("\n%s", buf.String())
+	if !strings.Contains(buf.String(), "~ host web01\n") || !strings.Contains(buf.String(), "    ~ address 10.0.0.1 => 10.0.0.2\n") {
+		t.Errorf("Unexpected text output:\n%s", buf.String())
+	}
+
+	b2, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tmp map[string][]map[string]interface{}
+	err = json.Unmarshal(b2, &tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmp["modified"][0]["type"] != "host" || tmp["modified"][0]["kind"] != "modified" {
+		t.Errorf("Unexpected JSON output: %s", b2)
+	}
+
+	if !Diff(a, a).Empty() {
+		t.Error("Expected no difference between a config and itself")
+	}
+}
@@ -0,0 +1,442 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+The relationships between objects, as a graph with an edge for every reference from one object to another.
+Edges are typed by the directive making the reference, e.g. "parents" from a host to each of its parents,
+"members" from a hostgroup to each host, "check_command" from a service to its command, and "use" to templates.
+Dependencies also get edges straight from each dependent host or service to the one it depends on, typed
+EDGE_DEPENDS, with the dependency object as Via.
+
+References are taken as written in each object, without template resolving (see resolve.go), so that each
+edge points to the object and key holding it. Names that are not found, "*", exclusions ("!name") and "null"
+give no edges, and neither do hostgroup or servicegroup names in dependencies, only the hosts and services
+given directly. Services are referenced by host_name and service_description, and are found on every host they
+apply to, also those in the hostgroups in their hostgroup_name (see lookup.go).
+
+Nagios refuses to start with loops in parents, group nesting, templates or dependencies. Cycles finds them.
+The graph is not kept up to date with changes to the config, make a new one after changing it.
+*/
+
+import (
+	"sort"
+	"strings"
+)
+
+const EDGE_DEPENDS string = "depends_on" // derived edge from a dependent host or service to its master
+
+// refKeys maps directives referencing other objects to the type referenced. "members", "use" and the
+// service_description keys depend on the type of the referencing object, and are handled in NewGraph.
+var refKeys = map[string]CfgType{
+	"parents":                       T_HOST,
+	"host_name":                     T_HOST,
+	"dependent_host_name":           T_HOST,
+	"hostgroups":                    T_HOSTGROUP,
+	"hostgroup_name":                T_HOSTGROUP,
+	"dependent_hostgroup_name":      T_HOSTGROUP,
+	"hostgroup_members":             T_HOSTGROUP,
+	"servicegroups":                 T_SERVICEGROUP,
+	"servicegroup_name":             T_SERVICEGROUP,
+	"dependent_servicegroup_name":   T_SERVICEGROUP,
+	"servicegroup_members":          T_SERVICEGROUP,
+	"contacts":                      T_CONTACT,
+	"contact_groups":                T_CONTACTGROUP,
+	"contactgroups":                 T_CONTACTGROUP,
+	"contactgroup_members":          T_CONTACTGROUP,
+	"check_command":                 T_COMMAND,
+	"event_handler":                 T_COMMAND,
+	"host_notification_commands":    T_COMMAND,
+	"service_notification_commands": T_COMMAND,
+	"check_period":                  T_TIMEPERIOD,
+	"notification_period":           T_TIMEPERIOD,
+	"host_notification_period":      T_TIMEPERIOD,
+	"service_notification_period":   T_TIMEPERIOD,
+	"escalation_period":             T_TIMEPERIOD,
+	"dependency_period":             T_TIMEPERIOD,
+	"exclude":                       T_TIMEPERIOD,
+}
+
+// membersOf maps group types to the type of their members
+var membersOf = map[CfgType]CfgType{
+	T_HOSTGROUP:    T_HOST,
+	T_CONTACTGROUP: T_CONTACT,
+	T_SERVICEGROUP: T_SERVICE,
+}
+
+// CYCLE_KEYS are the edge types where Nagios does not allow loops
+var CYCLE_KEYS = []string{
+	"parents",
+	"hostgroup_members",
+	"contactgroup_members",
+	"servicegroup_members",
+	"use",
+	EDGE_DEPENDS,
+}
+
+// Edge is a reference from one object to another
+type Edge struct {
+	Key  string // the directive in From making the reference, or EDGE_DEPENDS
+	From *CfgObj
+	To   *CfgObj
+	Via  *CfgObj // the dependency object, for EDGE_DEPENDS
+}
+
+// Graph holds all references between the objects in a config, see the top of this file
+type Graph struct {
+	cm    CfgMap
+	out   map[UUID][]Edge
+	in    map[UUID][]Edge
+	names *graphNames
+}
+
+// Cycle is a loop of references of the same type. The last object references the first.
+type Cycle struct {
+	Key  string
+	Objs CfgObjs
+}
+
+// graphNames finds objects by the names they're referenced by
+type graphNames struct {
+	byName map[CfgType]map[string]*CfgObj
+	svcs   map[string]*CfgObj  // host_name;service_description
+	descs  map[string][]string // host_name => descriptions of the services found on it
+	tpls   templates
+}
+
+func newGraphNames(cm CfgMap) *graphNames {
+	gn := &graphNames{
+		byName: make(map[CfgType]map[string]*CfgObj),
+		svcs:   make(map[string]*CfgObj),
+		descs:  make(map[string][]string),
+		tpls:   cm.templateIndex(),
+	}
+	for _, u := range cm.Keys() {
+		co := cm[u]
+		if co.isTemplate() {
+			continue
+		}
+		if co.Type == T_SERVICE {
+			continue // below
+		}
+		keys := identityKeys[co.Type]
+		if len(keys) != 1 {
+			continue
+		}
+		name, found := co.Get(keys[0])
+		if !found {
+			continue
+		}
+		if gn.byName[co.Type] == nil {
+			gn.byName[co.Type] = make(map[string]*CfgObj)
+		}
+		if _, found := gn.byName[co.Type][name]; !found {
+			gn.byName[co.Type][name] = co
+		}
+	}
+
+	// services are found on every host they apply to, also through hostgroup_name, where a service given
+	// directly in host_name wins over one with the same description applied through a hostgroup
+	for h, refs := range newHostRelations(cm).services {
+		for _, direct := range []bool{true, false} {
+			for _, r := range refs {
+				if r.direct != direct || r.desc == "" {
+					continue
+				}
+				if _, found := gn.svcs[h+";"+r.desc]; !found {
+					gn.svcs[h+";"+r.desc] = r.co
+					gn.descs[h] = append(gn.descs[h], r.desc)
+				}
+			}
+		}
+	}
+	return gn
+}
+
+// service returns the service with the given description on host, or nil if none
+func (gn *graphNames) service(host, desc string) *CfgObj {
+	return gn.svcs[host+";"+desc]
+}
+
+// services returns the services with any of the given descriptions on any of the given hosts
+func (gn *graphNames) services(hosts, descs []string) CfgObjs {
+	var res CfgObjs
+	for _, h := range hosts {
+		for _, d := range descs {
+			if svc, found := gn.svcs[h+";"+d]; found {
+				res = append(res, svc)
+			}
+		}
+	}
+	return res
+}
+
+// refNames returns the names referenced by val for key, skipping those that don't name a single object
+func refNames(key, val string) []string {
+	if key == "check_command" || key == "event_handler" {
+		if name := cmdName(val); name != "" {
+			return []string{name}
+		}
+		return nil
+	}
+	var names []string
+	for _, v := range splitList(strings.TrimPrefix(val, TPL_ADDITIVE)) {
+		if v == "" || v == LST_ALL || v == TPL_NULL || strings.HasPrefix(v, LST_EXCLUDE) {
+			continue
+		}
+		names = append(names, v)
+	}
+	return names
+}
+
+// NewGraph builds the graph for cm
+func NewGraph(cm CfgMap) *Graph {
+	g := &Graph{
+		cm:  cm,
+		out: make(map[UUID][]Edge),
+		in:  make(map[UUID][]Edge),
+	}
+	gn := newGraphNames(cm)
+	g.names = gn
+	for _, u := range cm.Keys() {
+		co := cm[u]
+		keys := make([]string, 0, len(co.Props))
+		for k := range co.Props {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, to := range g.targets(gn, co, k) {
+				g.add(Edge{Key: k, From: co, To: to})
+			}
+		}
+		g.addDepends(gn, co)
+	}
+	return g
+}
+
+// Graph builds the graph for the config
+func (nc *NagiosCfg) Graph() *Graph {
+	return NewGraph(nc.Config)
+}
+
+func (g *Graph) add(e Edge) {
+	g.out[e.From.UUID] = append(g.out[e.From.UUID], e)
+	g.in[e.To.UUID] = append(g.in[e.To.UUID], e)
+}
+
+// targets returns the objects referenced by key in co
+func (g *Graph) targets(gn *graphNames, co *CfgObj, key string) CfgObjs {
+	names := refNames(key, co.Props[key])
+	var res CfgObjs
+	switch {
+	case key == "use":
+		for _, n := range names {
+			if tpl, found := gn.tpls[co.Type][n]; found {
+				res = append(res, tpl)
+			}
+		}
+		return res
+	case key == "members" && co.Type == T_SERVICEGROUP:
+		for i := 0; i+1 < len(names); i += 2 { // host,description pairs
+			res = append(res, gn.services(names[i:i+1], names[i+1:i+2])...)
+		}
+		return res
+	case key == "service_description" && co.Type != T_SERVICE:
+		return gn.services(refNames("host_name", co.Props["host_name"]), names)
+	case key == "dependent_service_description":
+		hosts := refNames("dependent_host_name", co.Props["dependent_host_name"])
+		if len(hosts) == 0 {
+			hosts = refNames("host_name", co.Props["host_name"])
+		}
+		return gn.services(hosts, names)
+	}
+
+	ct, found := refKeys[key]
+	if key == "members" {
+		ct, found = membersOf[co.Type]
+	}
+	if !found || ct == co.Type && len(identityKeys[ct]) == 1 && identityKeys[ct][0] == key {
+		return nil // not a reference, or the name of the object itself
+	}
+	for _, n := range names {
+		if to, found := gn.byName[ct][n]; found {
+			res = append(res, to)
+		}
+	}
+	return res
+}
+
+// addDepends adds EDGE_DEPENDS edges for co, if it's a dependency
+func (g *Graph) addDepends(gn *graphNames, co *CfgObj) {
+	var dependent, master CfgObjs
+	switch co.Type {
+	case T_HOSTDEPENDENCY:
+		dependent = g.targets(gn, co, "dependent_host_name")
+		master = g.targets(gn, co, "host_name")
+	case T_SERVICEDEPENDENCY:
+		dependent = g.targets(gn, co, "dependent_service_description")
+		master = g.targets(gn, co, "service_description")
+	default:
+		return
+	}
+	for _, d := range dependent {
+		for _, m := range master {
+			g.add(Edge{Key: EDGE_DEPENDS, From: d, To: m, Via: co})
+		}
+	}
+}
+
+// hasKey tells if key is one of keys, or if keys is empty
+func hasKey(keys []string, key string) bool {
+	if len(keys) == 0 {
+		return true
+	}
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func filterEdges(es []Edge, keys []string) []Edge {
+	var res []Edge
+	for _, e := range es {
+		if hasKey(keys, e.Key) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// Out returns the references from co, of the given types, or all if none given
+func (g *Graph) Out(co *CfgObj, keys ...string) []Edge {
+	return filterEdges(g.out[co.UUID], keys)
+}
+
+// In returns the references to co, of the given types, or all if none given
+func (g *Graph) In(co *CfgObj, keys ...string) []Edge {
+	return filterEdges(g.in[co.UUID], keys)
+}
+
+// References returns the objects referenced by co, each once, in the order referenced
+func (g *Graph) References(co *CfgObj, keys ...string) CfgObjs {
+	return g.walk(co, false, keys, 1)
+}
+
+// ReferencedBy returns the objects referencing co, each once
+func (g *Graph) ReferencedBy(co *CfgObj, keys ...string) CfgObjs {
+	return g.walk(co, true, keys, 1)
+}
+
+// Ancestors follows the references from co, and from the objects found, and so on, returning all objects
+// found, nearest first. For "parents", this is the parents of a host, their parents and so on. For
+// "hostgroup_members", it's the groups nested in a hostgroup, at any depth.
+func (g *Graph) Ancestors(co *CfgObj, keys ...string) CfgObjs {
+	return g.walk(co, false, keys, -1)
+}
+
+// Descendants is the reverse of Ancestors, following references to co instead of from it. For "parents",
+// this is all hosts below co.
+func (g *Graph) Descendants(co *CfgObj, keys ...string) CfgObjs {
+	return g.walk(co, true, keys, -1)
+}
+
+// walk does a breadth first search from co, to the given depth, or all the way if depth < 0
+func (g *Graph) walk(co *CfgObj, reverse bool, keys []string, depth int) CfgObjs {
+	seen := map[UUID]bool{co.UUID: true}
+	var res CfgObjs
+	level := CfgObjs{co}
+	for ; len(level) > 0 && depth != 0; depth-- {
+		var next CfgObjs
+		for _, c := range level {
+			es := g.Out(c, keys...)
+			if reverse {
+				es = g.In(c, keys...)
+			}
+			for _, e := range es {
+				o := e.To
+				if reverse {
+					o = e.From
+				}
+				if !seen[o.UUID] {
+					seen[o.UUID] = true
+					next = append(next, o)
+				}
+			}
+		}
+		res = append(res, next...)
+		level = next
+	}
+	return res
+}
+
+// Cycles returns the loops of CYCLE_KEYS references, which Nagios does not allow. Each loop is found once
+// for each way into it that closes it, so overlapping loops may give more than one Cycle.
+func (g *Graph) Cycles() []Cycle {
+	var cycles []Cycle
+	ids := g.cm.Keys()
+	for _, key := range CYCLE_KEYS {
+		const (
+			white = iota
+			grey
+			black
+		)
+		color := make(map[UUID]int)
+		var stack CfgObjs
+		var visit func(co *CfgObj)
+		visit = func(co *CfgObj) {
+			color[co.UUID] = grey
+			stack = append(stack, co)
+			for _, e := range g.Out(co, key) {
+				switch color[e.To.UUID] {
+				case white:
+					visit(e.To)
+				case grey:
+					i := len(stack) - 1
+					for stack[i] != e.To {
+						i--
+					}
+					objs := make(CfgObjs, len(stack)-i)
+					copy(objs, stack[i:])
+					cycles = append(cycles, Cycle{Key: key, Objs: objs})
+				}
+			}
+			stack = stack[:len(stack)-1]
+			color[co.UUID] = black
+		}
+		for _, u := range ids {
+			if color[u] == white {
+				visit(g.cm[u])
+			}
+		}
+	}
+	return cycles
+}
+
+// String gives the loop like "parents: web01 -> web02 -> web01"
+func (c Cycle) String() string {
+	names := make([]string, 0, len(c.Objs)+1)
+	for _, co := range c.Objs {
+		names = append(names, diffID(co))
+	}
+	if len(c.Objs) > 0 {
+		names = append(names, diffID(c.Objs[0]))
+	}
+	return c.Key + ": " + strings.Join(names, " -> ")
+}
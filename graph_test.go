@@ -0,0 +1,202 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"testing"
+)
+
+var graphcfgstr string = `define command{
+	command_name check_ping
+	command_line $USER1$/check_ping -H $HOSTADDRESS$ -w $ARG1$ -c $ARG2$
+}
+define timeperiod{
+	timeperiod_name workhours
+	monday 09:00-17:00
+}
+define host{
+	name generic-host
+	check_command check_ping!100.0,20%!500.0,60%
+	check_period workhours
+	register 0
+}
+define host{
+	use generic-host
+	host_name core
+}
+define host{
+	use generic-host
+	host_name switch
+	parents core
+}
+define host{
+	use generic-host
+	host_name web01
+	parents switch
+	hostgroups web
+}
+define hostgroup{
+	hostgroup_name web
+	hostgroup_members all
+}
+define hostgroup{
+	hostgroup_name all
+	members core,switch
+	hostgroup_members web
+}
+define service{
+	host_name web01
+	service_description HTTP
+	check_command check_ping!1!2
+}
+define service{
+	host_name web01
+	service_description HTTPS
+	check_command check_ping!1!2
+}
+define servicedependency{
+	host_name web01
+	service_description HTTP
+	dependent_service_description HTTPS
+}
+define servicedependency{
+	host_name web01
+	service_description HTTPS
+	dependent_service_description HTTP
+}
+`
+
+func TestGraphReferences(t *testing.T) {
+	nc := loadDiffCfg(t, graphcfgstr)
+	g := nc.Graph()
+	web01 := nc.GetHost("web01")
+
+	es := g.Out(web01)
+	keys := make([]string, len(es))
+	for i, e := range es {
+		keys[i] = e.Key + "=" + diffID(e.To)
+	}
+	if !sameStrings(keys, []string{"hostgroups=web", "parents=switch", "use=name=generic-host"}) {
+		t.Errorf("Unexpected references from web01: %v", keys)
+	}
+
+	cmd := nc.GetCommand("check_ping")
+	if users := g.ReferencedBy(cmd, "check_command"); len(users) != 3 {
+		t.Errorf("Expected the template and 2 services to use check_ping, got %d", len(users))
+	}
+	tp, _ := nc.Config.GetByIdentity(T_TIMEPERIOD, "workhours")
+	if users := g.ReferencedBy(tp); len(users) != 1 || !users[0].isTemplate() {
+		t.Errorf("Expected only the template to use workhours, got %v", users)
+	}
+
+	if got := hostNames(nc.Config, objIDs(g.Ancestors(web01, "parents"))); !sameStrings(got, []string{"switch", "core"}) {
+		t.Errorf("Ancestors: got %v", got)
+	}
+	if got := hostNames(nc.Config, objIDs(g.Descendants(nc.GetHost("core"), "parents"))); !sameStrings(got, []string{"switch", "web01"}) {
+		t.Errorf("Descendants: got %v", got)
+	}
+	all, _ := nc.Config.GetByIdentity(T_HOSTGROUP, "all")
+	if got := g.References(all, "members"); len(got) != 2 {
+		t.Errorf("Expected 2 members, got %d", len(got))
+	}
+}
+
+func TestGraphCycles(t *testing.T) {
+	nc := loadDiffCfg(t, graphcfgstr)
+	var got []string
+	for _, c := range nc.Graph().Cycles() {
+		got = append(got, c.String())
+	}
+	exp := []string{
+		"hostgroup_members: web -> all -> web",
+		"depends_on: web01;HTTP -> web01;HTTPS -> web01;HTTP",
+	}
+	if !sameStrings(got, exp) {
+		t.Errorf("Expected %v, got %v", exp, got)
+	}
+
+	nc.GetHost("core").Set("parents", "web01")
+	cycles := nc.Graph().Cycles()
+	if len(cycles) != 3 || cycles[0].String() != "parents: core -> web01 -> switch -> core" {
+		t.Errorf("Expected a parents loop first, got %v", cycles)
+	}
+}
+
+func objIDs(cos CfgObjs) UUIDs {
+	ids := make(UUIDs, len(cos))
+	for i := range cos {
+		ids[i] = cos[i].UUID
+	}
+	return ids
+}
+
+var graphgroupcfgstr string = `define host{
+    host_name   web01
+}
+define host{
+    host_name   web02
+}
+define hostgroup{
+    hostgroup_name  web
+    members         web01,web02
+}
+define service{
+    hostgroup_name       web
+    host_name            !web02
+    service_description  HTTP
+}
+define service{
+    host_name            web01
+    service_description  HTTPS
+}
+define servicegroup{
+    servicegroup_name  frontends
+    members            web01,HTTP,web02,HTTP
+}
+define servicedependency{
+    host_name                      web01
+    service_description            HTTP
+    dependent_host_name            web01
+    dependent_service_description  HTTPS
+}
+define servicedependency{
+    host_name                      web01
+    service_description            HTTPS
+    dependent_host_name            web01
+    dependent_service_description  HTTP
+}
+`
+
+func TestGraphHostgroupServices(t *testing.T) {
+	nc := loadDiffCfg(t, graphgroupcfgstr)
+	g := nc.Graph()
+	http := nc.GetService("web01", "HTTP")
+	if http == nil {
+		t.Fatal("HTTP not found on web01")
+	}
+
+	sg, _ := nc.Config.GetByIdentity(T_SERVICEGROUP, "frontends")
+	if got := g.References(sg, "members"); len(got) != 1 || got[0] != http {
+		t.Errorf("Expected only web01;HTTP as member, as web02 is excluded, got %v", got)
+	}
+	if users := g.ReferencedBy(http, "members"); len(users) != 1 || users[0] != sg {
+		t.Errorf("Expected the servicegroup to reference HTTP, got %v", users)
+	}
+	if cycles := g.Cycles(); len(cycles) != 1 || cycles[0].Key != EDGE_DEPENDS {
+		t.Errorf("Expected a dependency loop through the hostgroup service, got %v", cycles)
+	}
+}
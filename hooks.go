@@ -0,0 +1,234 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+Change notification, for things like the journal that need to know about every change to a config.
+Observers are attached to a CfgMap, and from then on get told about objects added or removed through
+CfgMap.SetByUUID/AddByUUID/DelByUUID (and the methods using them), and about properties changed through
+CfgObj.Set/Del on objects in the map. Changes made by writing to the map or to Props directly are not seen.
+
+As CfgMap is a plain map, observers are kept in a registry keyed by the map itself, and each object in an
+observed map points to the same set of observers, so that CfgObj methods don't need to know the map.
+An object can only report to one map, so adding an object from one observed map to another adds a copy.
+The registry keeps the observers, and through them the map, alive until they are detached. Observers attached
+for a NagiosCfg, like its journal, index and lookup cache, are detached when nothing can reach the NagiosCfg,
+or any copy of it, anymore. Observers attached directly, with NewJournal or NewIndex, must be closed by the
+caller.
+*/
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"reflect"
+	"runtime"
+	"sync"
+)
+
+// observer gets told about changes to an observed map. Values are "" for properties that don't exist.
+type observer interface {
+	propChanged(co *CfgObj, key, oldVal, newVal string)
+	objAdded(cm CfgMap, co *CfgObj)
+	objRemoved(cm CfgMap, co *CfgObj)
+}
+
+// batcher is implemented by observers that want to know which changes belong together
+type batcher interface {
+	begin(desc string)
+	end()
+}
+
+// observerSet is shared between a map and all objects in it. All methods are safe to call on nil.
+type observerSet struct {
+	list []observer
+}
+
+var mapObservers = struct {
+	sync.RWMutex
+	m map[uintptr]*observerSet
+}{m: make(map[uintptr]*observerSet)}
+
+// mapID identifies a map for as long as it lives, no matter which copy of the map header we have
+func mapID(cm CfgMap) uintptr {
+	return reflect.ValueOf(cm).Pointer()
+}
+
+// observersOf returns the observers of cm, or nil if none
+func observersOf(cm CfgMap) *observerSet {
+	if cm == nil {
+		return nil
+	}
+	mapObservers.RLock()
+	defer mapObservers.RUnlock()
+	return mapObservers.m[mapID(cm)]
+}
+
+// attachObserver makes o see all changes to cm and the objects in it
+func attachObserver(cm CfgMap, o observer) {
+	mapObservers.Lock()
+	defer mapObservers.Unlock()
+	id := mapID(cm)
+	set, found := mapObservers.m[id]
+	if !found {
+		set = &observerSet{}
+		mapObservers.m[id] = set
+	}
+	set.list = append(set.list, o)
+	for u, co := range cm {
+		cm[u] = adopt(co, set)
+	}
+}
+
+// detachObserver stops o from seeing changes to cm
+func detachObserver(cm CfgMap, o observer) {
+	mapObservers.Lock()
+	defer mapObservers.Unlock()
+	id := mapID(cm)
+	set, found := mapObservers.m[id]
+	if !found {
+		return
+	}
+	for i := range set.list {
+		if set.list[i] == o {
+			set.list = append(set.list[:i], set.list[i+1:]...)
+			break
+		}
+	}
+	if len(set.list) == 0 {
+		delete(mapObservers.m, id)
+		for _, co := range cm {
+			release(co, set)
+		}
+	}
+}
+
+// adopt makes co report changes to set, the observers of a map it's being put in, and returns it. An object
+// reporting to the observers of another map is copied instead, so that those keep seeing changes to it. Frozen
+// objects are shared, and never change, so they are returned as they are.
+func adopt(co *CfgObj, set *observerSet) *CfgObj {
+	if co.frozen || set == nil || co.obs == set {
+		return co
+	}
+	if co.obs != nil {
+		log.Debugf("Object %s is in another observed map, adding a copy %s", co.UUID, dbgStr(false))
+		co = co.Copy()
+	}
+	co.obs = set
+	return co
+}
+
+// release stops co from reporting changes to set, the observers of a map it's being removed from. Objects
+// reporting to another map are left alone.
+func release(co *CfgObj, set *observerSet) {
+	if !co.frozen && co.obs == set {
+		co.obs = nil
+	}
+}
+
+// closer is an observer that can detach itself
+type closer interface {
+	Close()
+}
+
+// hookOwner holds the observers attached for a NagiosCfg. It's only referenced by the NagiosCfg and copies of
+// it, never by the registry, so that it's collected when they are, and can then close the observers.
+type hookOwner struct {
+	sync.Mutex
+	closers []closer
+}
+
+func (ho *hookOwner) closeAll() {
+	ho.Lock()
+	defer ho.Unlock()
+	for _, c := range ho.closers {
+		c.Close()
+	}
+	ho.closers = nil
+}
+
+// own makes c be closed when nc is gone
+func (nc *NagiosCfg) own(c closer) {
+	if nc.owner == nil {
+		nc.owner = &hookOwner{}
+		runtime.SetFinalizer(nc.owner, (*hookOwner).closeAll)
+	}
+	nc.owner.Lock()
+	nc.owner.closers = append(nc.owner.closers, c)
+	nc.owner.Unlock()
+}
+
+// disown forgets c, after it has been closed
+func (nc *NagiosCfg) disown(c closer) {
+	if nc.owner == nil {
+		return
+	}
+	nc.owner.Lock()
+	defer nc.owner.Unlock()
+	for i := range nc.owner.closers {
+		if nc.owner.closers[i] == c {
+			nc.owner.closers = append(nc.owner.closers[:i], nc.owner.closers[i+1:]...)
+			return
+		}
+	}
+}
+
+// beginBatch tells the observers of cm that the following changes belong together, until the returned
+// function is called. Meant to be used as "defer beginBatch(cm, desc)()".
+func beginBatch(cm CfgMap, desc string) func() {
+	set := observersOf(cm)
+	if set == nil {
+		return func() {}
+	}
+	for _, o := range set.list {
+		if b, ok := o.(batcher); ok {
+			b.begin(desc)
+		}
+	}
+	return func() {
+		for _, o := range set.list {
+			if b, ok := o.(batcher); ok {
+				b.end()
+			}
+		}
+	}
+}
+
+func (set *observerSet) propChanged(co *CfgObj, key, oldVal, newVal string) {
+	if set == nil {
+		return
+	}
+	for _, o := range set.list {
+		o.propChanged(co, key, oldVal, newVal)
+	}
+}
+
+func (set *observerSet) objAdded(cm CfgMap, co *CfgObj) {
+	if set == nil {
+		return
+	}
+	for _, o := range set.list {
+		o.objAdded(cm, co)
+	}
+}
+
+func (set *observerSet) objRemoved(cm CfgMap, co *CfgObj) {
+	if set == nil {
+		return
+	}
+	for _, o := range set.list {
+		o.objRemoved(cm, co)
+	}
+}
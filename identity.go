@@ -0,0 +1,182 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+Natural identity of objects, i.e. what Nagios knows them by, as opposed to our UUIDs that change on every load.
+
+Objects with a name of their own are identified by it: host_name for hosts, command_name for commands and so on.
+Services are identified by host_name;service_description, just like op5 does (see GetUniqueCheckName), or by
+hostgroup_name=<hostgroup>;service_description=<description> when defined for a hostgroup.
+Dependencies, escalations and the other types without a name of their own get a composite key made from the
+properties that tell them apart, as key=value pairs separated by ";", e.g.
+"host_name=web01;dependent_host_name=web02" for a hostdependency.
+Templates without the name for their type are identified by "name=<name>", so that a template never collides
+with a real object of the same name.
+
+Identities are only unique within a type.
+*/
+
+import (
+	"strings"
+)
+
+// identityKeys lists the properties making up the identity for each type. Types with a single key use the
+// value as it is, the others get key=value pairs.
+var identityKeys = map[CfgType][]string{
+	T_COMMAND:      {"command_name"},
+	T_CONTACT:      {"contact_name"},
+	T_CONTACTGROUP: {"contactgroup_name"},
+	T_HOST:         {"host_name"},
+	T_HOSTDEPENDENCY: {
+		"host_name",
+		"hostgroup_name",
+		"dependent_host_name",
+		"dependent_hostgroup_name",
+	},
+	T_HOSTESCALATION: {
+		"host_name",
+		"hostgroup_name",
+		"first_notification",
+		"last_notification",
+	},
+	T_HOSTEXTINFO: {"host_name"},
+	T_HOSTGROUP:   {"hostgroup_name"},
+	T_SERVICE: {
+		"host_name",
+		"hostgroup_name",
+		"service_description",
+	},
+	T_SERVICEDEPENDENCY: {
+		"host_name",
+		"hostgroup_name",
+		"servicegroup_name",
+		"service_description",
+		"dependent_host_name",
+		"dependent_hostgroup_name",
+		"dependent_servicegroup_name",
+		"dependent_service_description",
+	},
+	T_SERVICEESCALATION: {
+		"host_name",
+		"hostgroup_name",
+		"servicegroup_name",
+		"service_description",
+		"first_notification",
+		"last_notification",
+	},
+	T_SERVICEEXTINFO: {
+		"host_name",
+		"service_description",
+	},
+	T_SERVICEGROUP: {"servicegroup_name"},
+	T_TIMEPERIOD:   {"timeperiod_name"},
+}
+
+// compositeIdentity joins the given keys that are set in co as key=value pairs
+func (co *CfgObj) compositeIdentity(keys []string) (string, bool) {
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if v, found := co.Get(k); found {
+			parts = append(parts, k+"="+v)
+		}
+	}
+	if len(parts) == 0 {
+		return "", false
+	}
+	return strings.Join(parts, ";"), true
+}
+
+// Identity returns the canonical unique key for the object within its type, as described at the top of
+// this file. ok is false if the object has nothing to identify it by.
+func (co *CfgObj) Identity() (id string, ok bool) {
+	keys := identityKeys[co.Type]
+	switch {
+	case co.Type == T_SERVICE:
+		id, ok = co.GetUniqueCheckName()
+		if !ok {
+			if _, hasDesc := co.Get("service_description"); hasDesc {
+				id, ok = co.compositeIdentity(keys[1:])
+			}
+		}
+	case len(keys) == 1:
+		id, ok = co.Get(keys[0])
+	case len(keys) > 1:
+		id, ok = co.compositeIdentity(keys)
+	}
+	if ok {
+		return id, ok
+	}
+	if name, found := co.Get("name"); found {
+		return "name=" + name, true
+	}
+	return "", false
+}
+
+// typedIdentity returns the identity prefixed by the type, for uniqueness across types
+func (co *CfgObj) typedIdentity() (string, bool) {
+	id, ok := co.Identity()
+	if !ok {
+		return "", false
+	}
+	return co.Type.String() + "/" + id, true
+}
+
+// GetByIdentity returns the first object, in original order, of the given type and identity
+func (cm CfgMap) GetByIdentity(ct CfgType, id string) (*CfgObj, bool) {
+	ids := cm.FindByIdentity(ct, id)
+	if len(ids) == 0 {
+		return nil, false
+	}
+	return cm[ids[0]], true
+}
+
+// FindByIdentity returns all objects of the given type and identity, in original order.
+// More than one means there's a collision.
+func (cm CfgMap) FindByIdentity(ct CfgType, id string) UUIDs {
+	if idx := indexOf(cm); idx != nil {
+		return idx.ByIdentity(ct, id)
+	}
+	var ids UUIDs
+	for _, k := range cm.Keys() {
+		co := cm[k]
+		if co.Type != ct {
+			continue
+		}
+		if oid, ok := co.Identity(); ok && oid == id {
+			ids = append(ids, k)
+		}
+	}
+	return ids
+}
+
+// IdentityCollisions returns all identities shared by more than one object, as "<type>/<identity>" mapped to
+// the objects, in original order. Objects without identity are not included.
+func (cm CfgMap) IdentityCollisions() map[string]UUIDs {
+	all := make(map[string]UUIDs)
+	for _, k := range cm.Keys() {
+		if tid, ok := cm[k].typedIdentity(); ok {
+			all[tid] = append(all[tid], k)
+		}
+	}
+	for tid, ids := range all {
+		if len(ids) < 2 {
+			delete(all, tid)
+		}
+	}
+	return all
+}
@@ -0,0 +1,105 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"strings"
+	"testing"
+)
+
+var identitycfgstr string = `define host{
+	name generic-host
+	register 0
+}
+define host{
+	host_name web01
+	use generic-host
+}
+define host{
+	host_name web01
+	alias duplicate
+}
+define service{
+	host_name web01
+	service_description HTTP
+}
+define service{
+	hostgroup_name webservers
+	service_description HTTP
+}
+define hostdependency{
+	host_name web01
+	dependent_host_name web02
+}
+define serviceescalation{
+	host_name web01
+	service_description HTTP
+	first_notification 3
+	last_notification 0
+}
+define command{
+	command_name check_http
+	command_line /bin/true
+}
+define hostextinfo{
+	notes nothing to identify this by
+}
+`
+
+func TestIdentity(t *testing.T) {
+	cm, err := NewReader(strings.NewReader(identitycfgstr)).ReadAllMap("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := cm.Keys()
+	exp := []struct {
+		id string
+		ok bool
+	}{
+		{"name=generic-host", true},
+		{"web01", true},
+		{"web01", true},
+		{"web01;HTTP", true},
+		{"hostgroup_name=webservers;service_description=HTTP", true},
+		{"host_name=web01;dependent_host_name=web02", true},
+		{"host_name=web01;service_description=HTTP;first_notification=3;last_notification=0", true},
+		{"check_http", true},
+		{"", false},
+	}
+	if len(keys) != len(exp) {
+		t.Fatalf("Expected %d objects, got %d", len(exp), len(keys))
+	}
+	for i, k := range keys {
+		id, ok := cm[k].Identity()
+		if id != exp[i].id || ok != exp[i].ok {
+			t.Errorf("Expected identity %q (%t) for %s, got %q (%t)", exp[i].id, exp[i].ok, cm[k].Type, id, ok)
+		}
+	}
+
+	co, found := cm.GetByIdentity(T_SERVICE, "web01;HTTP")
+	if !found || co.Props["host_name"] != "web01" {
+		t.Errorf("Lookup by identity failed: %+v", co)
+	}
+	if _, found := cm.GetByIdentity(T_COMMAND, "web01"); found {
+		t.Error("Identities should only match within type")
+	}
+
+	coll := cm.IdentityCollisions()
+	if len(coll) != 1 || len(coll["host/web01"]) != 2 {
+		t.Errorf("Expected collision for host/web01 only, got %v", coll)
+	}
+}
@@ -98,8 +98,8 @@ func (nc *NagiosCfg) LoadStdin() (err error) {
 	return err
 }
 
-func (nc *NagiosCfg) DumpStdout() {
-	nc.Print(os.Stdout, true) // sort by default
+func (nc *NagiosCfg) DumpStdout() error {
+	return nc.Print(os.Stdout, true) // sort by default
 }
 
 func (nc *NagiosCfg) InPipe() bool {
@@ -128,12 +128,17 @@ func (nc *NagiosCfg) Search(q *CfgQuery) UUIDs {
 }
 
 func (nc *NagiosCfg) InverseResults() UUIDs {
+	// Keys has only the objects in this config, unlike the shared read order
 	if nc.matches.Empty() {
-		return uuidorder // if previous search yielded nothing, then everything is the inverse
+		return nc.Config.Keys() // if previous search yielded nothing, then everything is the inverse
 	}
 	inv := make(UUIDs, 0, nc.Config.Len()-nc.matches.Len())
-	for _, v := range uuidorder {
-		if !v.In(nc.matches) { // this is probably slow
+	matched := make(uuidSet, len(nc.matches))
+	for _, v := range nc.matches {
+		matched[v] = struct{}{}
+	}
+	for _, v := range nc.Config.Keys() {
+		if _, found := matched[v]; !found {
 			inv = append(inv, v)
 		}
 	}
@@ -157,6 +162,7 @@ func (nc *NagiosCfg) DeleteMatches() CfgMap {
 	if nc.matches.Empty() {
 		return nil
 	}
+	defer beginBatch(nc.Config, "DeleteMatches")()
 	cm := make(CfgMap)
 	for i := range nc.matches {
 		cm[nc.matches[i]] = nc.Config.DelByUUID(nc.matches[i])
@@ -166,10 +172,12 @@ func (nc *NagiosCfg) DeleteMatches() CfgMap {
 }
 
 func (nc *NagiosCfg) DelKeys(keys []string) int {
+	defer beginBatch(nc.Config, "DelKeys")()
 	return nc.Config.DelKeys(nc.matches, keys)
 }
 
 func (nc *NagiosCfg) SetKeys(keys, values []string) int {
+	defer beginBatch(nc.Config, "SetKeys")()
 	return nc.Config.SetKeys(nc.matches, keys, values)
 }
 
@@ -178,6 +186,7 @@ func (nc *NagiosCfg) HasServiceDuplicates() (bool, map[string]UUIDs) {
 }
 
 func (nc *NagiosCfg) RemoveServiceDuplicates(dups map[string]UUIDs) int {
+	defer beginBatch(nc.Config, "RemoveServiceDuplicates")()
 	return nc.Config.RemoveDuplicateServices(dups)
 }
 
@@ -207,6 +216,13 @@ func (ct CfgType) In(types []CfgType) bool {
 	return false
 }
 
+// SortIndex returns the position of the given key within objects of this type, as defined in CfgKeySortOrder.
+// found is false if the key has no defined position for the type.
+func (ct CfgType) SortIndex(key string) (idx int, found bool) {
+	idx, found = CfgKeySortOrder[key][ct]
+	return
+}
+
 // Type returns the int (CfgType) value for the given CfgName, or -1 if not valid
 func (cn CfgName) Type() CfgType {
 	for i := range CfgTypes {
@@ -223,11 +239,20 @@ func (cn CfgName) Valid() bool {
 	return cn.Type() != T_INVALID
 }
 
+// IsValidProperty returns true for the keys defined in CfgKeySortOrder, and for custom variables
 func IsValidProperty(key string) bool {
+	if IsCustomVar(key) {
+		return true
+	}
 	_, ok := CfgKeySortOrder[key]
 	return ok
 }
 
+// IsCustomVar returns true if the key is a custom object variable, e.g. "_SNMP_COMMUNITY"
+func IsCustomVar(key string) bool {
+	return len(key) > 1 && key[0] == '_'
+}
+
 func ValidCfgNames() []string {
 	l := len(CfgTypes)
 	s := make([]string, l)
@@ -322,18 +347,22 @@ func (cq *CfgQuery) AddKeyRX(key, re string) bool {
 func (nc *NagiosCfg) MarshalJSON() ([]byte, error) {
 	buf := bytes.NewBufferString("{")
 
-	var pmap = map[string]interface{}{
-		"sessionid": nc.SessionID,
-		"date":      time.Now(),
-		"version":   VERSION,
+	// fixed order, so that documents are stable and readable
+	var fields = []struct {
+		k string
+		v interface{}
+	}{
+		{"sessionid", nc.SessionID},
+		{"date", time.Now()},
+		{"version", VERSION},
 	}
 
-	for k, v := range pmap {
-		jk, err := json.Marshal(k)
+	for _, f := range fields {
+		jk, err := json.Marshal(f.k)
 		if err != nil {
 			return nil, err
 		}
-		jv, err := json.Marshal(v)
+		jv, err := json.Marshal(f.v)
 		if err != nil {
 			return nil, err
 		}
@@ -358,6 +387,34 @@ func (nc *NagiosCfg) MarshalJSON() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// UnmarshalJSON loads a document written by MarshalJSON. The "date" and "version" fields are informational only,
+// and ignored. A document without a session ID gets a new one.
 func (nc *NagiosCfg) UnmarshalJSON(b []byte) error {
+	var tmp struct {
+		SessionID *UUID           `json:"sessionid"`
+		Cfg       json.RawMessage `json:"cfg"`
+	}
+	err := json.Unmarshal(b, &tmp)
+	if err != nil {
+		return err
+	}
+
+	cm := make(CfgMap)
+	if len(tmp.Cfg) > 0 && string(tmp.Cfg) != "null" {
+		err = json.Unmarshal(tmp.Cfg, &cm)
+		if err != nil {
+			return err
+		}
+	}
+
+	if tmp.SessionID != nil {
+		nc.SessionID = *tmp.SessionID
+	} else {
+		nc.SessionID = NewUUIDv1()
+	}
+	nc.Config = cm
+	nc.pipe = false
+	nc.matches = nil
+
 	return nil
 }
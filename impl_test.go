@@ -17,6 +17,7 @@
 package nagioscfg
 
 import (
+	"bytes"
 	"container/list"
 	"fmt"
 	"io/ioutil"
@@ -282,6 +283,67 @@ define service{
 	co.PrintPropsSorted(os.Stdout, "%s = %s\n")
 }
 
+func TestPrintPropsSortedAllKeys(t *testing.T) {
+	extra := []string{"_CUSTOM_VAR", "zz_unknown_key"} // no defined order, and the last one not even valid via Set
+	for ct := T_COMMAND; ct < T_INVALID; ct++ {
+		o := NewCfgObj(ct)
+		for _, k := range CfgKeys {
+			o.Set(k, "val_"+k)
+		}
+		for _, k := range extra {
+			o.Props[k] = "val_" + k
+		}
+
+		var buf bytes.Buffer
+		o.PrintPropsSorted(&buf, "%s %s\n")
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if len(lines) != len(CfgKeys)+len(extra) {
+			t.Errorf("%s: expected %d lines, but got %d", ct, len(CfgKeys)+len(extra), len(lines))
+		}
+
+		seen := make(map[string]bool)
+		lastKnown := -1
+		prevUnknown := ""
+		for _, l := range lines {
+			f := strings.SplitN(l, " ", 2)
+			if len(f) != 2 || f[1] != "val_"+f[0] {
+				t.Errorf("%s: malformed line %q", ct, l)
+				continue
+			}
+			if seen[f[0]] {
+				t.Errorf("%s: key %q printed more than once", ct, f[0])
+			}
+			seen[f[0]] = true
+			idx, known := ct.SortIndex(f[0])
+			if known {
+				if prevUnknown != "" {
+					t.Errorf("%s: known key %q printed after unknown key %q", ct, f[0], prevUnknown)
+				}
+				if idx < lastKnown {
+					t.Errorf("%s: key %q with index %d printed after index %d", ct, f[0], idx, lastKnown)
+				}
+				lastKnown = idx
+			} else {
+				if f[0] < prevUnknown {
+					t.Errorf("%s: unknown key %q not in alphabetical order after %q", ct, f[0], prevUnknown)
+				}
+				prevUnknown = f[0]
+			}
+		}
+		for _, k := range CfgKeys {
+			if !seen[k] {
+				t.Errorf("%s: key %q missing from output", ct, k)
+			}
+		}
+
+		var buf2 bytes.Buffer
+		o.PrintPropsSorted(&buf2, "%s %s\n")
+		if buf.String() != buf2.String() {
+			t.Errorf("%s: output differs between calls", ct)
+		}
+	}
+}
+
 func BenchmarkPrintProps(b *testing.B) {
 	objstr := `#comment 
 define service{
@@ -541,3 +603,21 @@ func TestCfgMapSearch(t *testing.T) {
 		m[u[i]].Print(os.Stdout, true)
 	}
 }
+
+func TestInverseResultsOwnObjects(t *testing.T) {
+	loadDiffCfg(t, indexcfgstr) // other objects in the shared read order
+	nc := loadDiffCfg(t, querycfgstr)
+	if inv := nc.InverseResults(); len(inv) != nc.Len() {
+		t.Errorf("Expected all %d objects in the config, got %d", nc.Len(), len(inv))
+	}
+	nc.SearchQuery(MustParseQuery(`type=host`))
+	inv := nc.InverseResults()
+	if len(inv) != nc.Len()-1 {
+		t.Errorf("Expected %d objects, got %d", nc.Len()-1, len(inv))
+	}
+	for _, u := range inv {
+		if _, found := nc.Config[u]; !found {
+			t.Errorf("%s is not in the config", u)
+		}
+	}
+}
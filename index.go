@@ -0,0 +1,454 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+Secondary indexes, so that common lookups don't need to scan the whole config.
+An Index is attached to a CfgMap as an observer (see hooks.go), and is kept up to date on every change made
+through CfgMap.Add/Set/Del and CfgObj.Set/Del. Objects are indexed by type, by identity, by each value in the
+list keys in INDEX_KEYS (so host_name=web01 finds services on web01, and hostgroups=web finds the hosts in it),
+and by the commands they use. The keys in INDEX_KEYS are also indexed by their whole value, so that regular
+expressions can be matched against each distinct value once, instead of against every object.
+
+Changes made directly to the map bypass the observers. If the number of objects no longer matches, the index is
+rebuilt on the next lookup, but edits to Props behind its back are not noticed. The index of a SafeCfg is
+only ever rebuilt with the write lock held, as readers share it.
+
+CfgMap.FilterType, FindByIdentity, Search and SearchQuery use the index of the map automatically, if there is
+one. Search only does so for queries with as many keys as regexes, where each regex goes with its key.
+
+NagiosCfg.Index follows the config: if NagiosCfg.Config is replaced, the index is moved to the new map.
+*/
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// INDEX_KEYS are the properties indexed by value. The values are split as comma separated lists.
+var INDEX_KEYS = []string{
+	"host_name",
+	"hostgroup_name",
+	"hostgroups",
+	"members",
+	"name",
+	"use",
+	"contacts",
+	"contact_groups",
+	"servicegroups",
+}
+
+// cmdKeys are the properties referring to commands. Arguments after "!" are not part of the command name.
+var cmdKeys = []string{
+	"check_command",
+	"event_handler",
+	"host_notification_commands",
+	"service_notification_commands",
+}
+
+// Prefixes for the different kinds of entries in Index.entries
+const (
+	idxType string = "t\x00"
+	idxID   string = "i\x00"
+	idxKey  string = "k\x00"
+	idxCmd  string = "c\x00"
+	idxVal  string = "v\x00"
+)
+
+type uuidSet map[UUID]struct{}
+
+// Index is a set of secondary indexes for a CfgMap. See the top of this file.
+type Index struct {
+	cm      CfgMap
+	entries map[string]uuidSet             // prefixed entry => objects
+	vals    map[string]map[string]struct{} // key => whole values with an idxVal entry
+	keysOf  map[UUID][]string              // object => the entries it's in, for removal
+	pos     map[UUID]int                   // original order, so that results come out the same as with a scan
+	nextPos int
+	count   int  // number of objects indexed, to notice changes made behind our back
+	shared  bool // read without a write lock (see SafeCfg), so lookups must not rebuild it
+}
+
+// NewIndex indexes all objects in cm, and keeps the index updated until Close is called
+func NewIndex(cm CfgMap) *Index {
+	idx := &Index{cm: cm}
+	idx.rebuild()
+	attachObserver(cm, idx)
+	return idx
+}
+
+// Close stops updating the index, and detaches it from the map
+func (idx *Index) Close() {
+	detachObserver(idx.cm, idx)
+	idx.cm = nil
+}
+
+// EnableIndex indexes the config, and keeps the index up to date. If already enabled, the existing index is
+// returned.
+func (nc *NagiosCfg) EnableIndex() *Index {
+	if nc.index != nil && mapID(nc.index.cm) != mapID(nc.Config) {
+		nc.DisableIndex() // made for a config since replaced
+	}
+	if nc.index == nil {
+		nc.index = NewIndex(nc.Config)
+		nc.own(nc.index)
+	}
+	return nc.index
+}
+
+// DisableIndex drops the index for the config
+func (nc *NagiosCfg) DisableIndex() {
+	if nc.index != nil {
+		nc.index.Close()
+		nc.disown(nc.index)
+		nc.index = nil
+	}
+}
+
+// Index returns the index for the config, or nil if not enabled. If the config has been replaced since the
+// index was made, it's moved to the new config first.
+func (nc *NagiosCfg) Index() *Index {
+	if nc.index != nil && mapID(nc.index.cm) != mapID(nc.Config) {
+		nc.DisableIndex()
+		nc.EnableIndex()
+	}
+	return nc.index
+}
+
+// indexOf returns the index attached to cm, or nil if none
+func indexOf(cm CfgMap) *Index {
+	set := observersOf(cm)
+	if set == nil {
+		return nil
+	}
+	for _, o := range set.list {
+		if idx, ok := o.(*Index); ok {
+			if !idx.fresh() {
+				return nil // out of date, and can't be rebuilt now, so scan instead
+			}
+			return idx
+		}
+	}
+	return nil
+}
+
+func (idx *Index) rebuild() {
+	idx.entries = make(map[string]uuidSet)
+	idx.vals = make(map[string]map[string]struct{})
+	idx.keysOf = make(map[UUID][]string, len(idx.cm))
+	idx.pos = make(map[UUID]int, len(idx.cm))
+	idx.nextPos = 0
+	idx.count = 0
+	for _, u := range idx.cm.Keys() {
+		idx.add(idx.cm[u])
+	}
+}
+
+// fresh rebuilds the index if the map has been changed without us knowing. A shared index is never rebuilt
+// here, as readers may be using it, and false is returned if it's out of date.
+func (idx *Index) fresh() bool {
+	if idx.cm == nil || idx.count == len(idx.cm) {
+		return true
+	}
+	if idx.shared {
+		return false
+	}
+	idx.rebuild()
+	return true
+}
+
+// splitList splits a list value the same way for indexing and lookups
+func splitList(val string) []string {
+	vals := strings.Split(val, SEP_LST)
+	for i := range vals {
+		vals[i] = strings.TrimSpace(vals[i])
+	}
+	return vals
+}
+
+// cmdName returns the command name from a value like "check_ping!100.0,20%!500.0,60%"
+func cmdName(val string) string {
+	return strings.TrimSpace(strings.SplitN(val, SEP_CMD, 2)[0])
+}
+
+// entriesFor returns all index entries for co
+func entriesFor(co *CfgObj) []string {
+	es := []string{idxType + co.Type.String()}
+	if id, ok := co.typedIdentity(); ok {
+		es = append(es, idxID+id)
+	}
+	for _, k := range INDEX_KEYS {
+		if val, found := co.Get(k); found {
+			es = append(es, idxVal+k+"\x00"+val)
+			for _, v := range splitList(val) {
+				es = append(es, idxKey+k+"\x00"+v)
+			}
+		}
+	}
+	for _, k := range cmdKeys {
+		val, found := co.Get(k)
+		if !found {
+			continue
+		}
+		if k == "check_command" || k == "event_handler" {
+			es = append(es, idxCmd+cmdName(val))
+			continue
+		}
+		for _, v := range splitList(val) {
+			es = append(es, idxCmd+v)
+		}
+	}
+	return es
+}
+
+func (idx *Index) add(co *CfgObj) {
+	if _, found := idx.keysOf[co.UUID]; found {
+		idx.remove(co)
+	}
+	if _, found := idx.pos[co.UUID]; !found {
+		idx.pos[co.UUID] = idx.nextPos
+		idx.nextPos++
+	}
+	es := entriesFor(co)
+	for _, e := range es {
+		set, found := idx.entries[e]
+		if !found {
+			set = make(uuidSet)
+			idx.entries[e] = set
+		}
+		set[co.UUID] = struct{}{}
+		if key, val, ok := splitValEntry(e); ok {
+			if idx.vals[key] == nil {
+				idx.vals[key] = make(map[string]struct{})
+			}
+			idx.vals[key][val] = struct{}{}
+		}
+	}
+	idx.keysOf[co.UUID] = es
+	idx.count++
+}
+
+// remove takes co out of the index, but keeps its position, in case it's added back (undo)
+func (idx *Index) remove(co *CfgObj) {
+	es, found := idx.keysOf[co.UUID]
+	if !found {
+		return
+	}
+	for _, e := range es {
+		delete(idx.entries[e], co.UUID)
+		if len(idx.entries[e]) == 0 {
+			delete(idx.entries, e)
+			if key, val, ok := splitValEntry(e); ok {
+				delete(idx.vals[key], val)
+			}
+		}
+	}
+	delete(idx.keysOf, co.UUID)
+	idx.count--
+}
+
+func (idx *Index) propChanged(co *CfgObj, key, oldVal, newVal string) {
+	idx.add(co) // re-index, as the identity may depend on the key as well
+}
+
+func (idx *Index) objAdded(cm CfgMap, co *CfgObj) {
+	idx.add(co)
+}
+
+func (idx *Index) objRemoved(cm CfgMap, co *CfgObj) {
+	idx.remove(co)
+}
+
+// splitValEntry returns the key and value of an idxVal entry, or false for other entries
+func splitValEntry(e string) (key, val string, ok bool) {
+	if !strings.HasPrefix(e, idxVal) {
+		return "", "", false
+	}
+	kv := strings.SplitN(e[len(idxVal):], "\x00", 2)
+	return kv[0], kv[1], true
+}
+
+// lookup returns the objects for the given entries, in original order, without duplicates
+func (idx *Index) lookup(es ...string) UUIDs {
+	idx.fresh()
+	var res UUIDs
+	seen := make(uuidSet)
+	for _, e := range es {
+		for u := range idx.entries[e] {
+			if _, found := seen[u]; !found {
+				seen[u] = struct{}{}
+				res = append(res, u)
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return idx.pos[res[i]] < idx.pos[res[j]]
+	})
+	return res
+}
+
+// ByType returns all objects of the given types
+func (idx *Index) ByType(ts ...CfgType) UUIDs {
+	es := make([]string, len(ts))
+	for i := range ts {
+		es[i] = idxType + ts[i].String()
+	}
+	return idx.lookup(es...)
+}
+
+// ByIdentity returns the objects of the given type and identity, see CfgObj.Identity
+func (idx *Index) ByIdentity(ct CfgType, id string) UUIDs {
+	return idx.lookup(idxID + ct.String() + "/" + id)
+}
+
+// ByValue returns the objects where the list in key contains val. Only keys in INDEX_KEYS are indexed.
+func (idx *Index) ByValue(key, val string) UUIDs {
+	return idx.lookup(idxKey + key + "\x00" + val)
+}
+
+// ServicesOnHost returns the services with the given host in host_name. See lookup.go for services applied
+// through hostgroups as well.
+func (idx *Index) ServicesOnHost(host string) UUIDs {
+	var res UUIDs
+	for _, u := range idx.ByValue("host_name", host) {
+		if idx.cm[u].Type == T_SERVICE {
+			res = append(res, u)
+		}
+	}
+	return res
+}
+
+// HostgroupMembers returns the hosts in the given hostgroup, either by their hostgroups directive, or the
+// members directive of the hostgroup
+func (idx *Index) HostgroupMembers(group string) UUIDs {
+	es := []string{}
+	for _, u := range idx.ByValue("hostgroups", group) {
+		if id, ok := idx.cm[u].typedIdentity(); ok && idx.cm[u].Type == T_HOST {
+			es = append(es, idxID+id)
+		}
+	}
+	for _, u := range idx.ByIdentity(T_HOSTGROUP, group) {
+		if members, found := idx.cm[u].Get("members"); found {
+			for _, m := range splitList(members) {
+				es = append(es, idxID+T_HOST.String()+"/"+m)
+			}
+		}
+	}
+	return idx.lookup(es...)
+}
+
+// Matching returns the objects with a value for key that matches rx, or false if key is not indexed
+func (idx *Index) Matching(key string, rx *regexp.Regexp) (UUIDs, bool) {
+	if !isIndexKey(key) {
+		return nil, false
+	}
+	idx.fresh()
+	var es []string
+	for val := range idx.vals[key] {
+		if rx.MatchString(val) {
+			es = append(es, idxVal+key+"\x00"+val)
+		}
+	}
+	return idx.lookup(es...), true
+}
+
+// searchCandidates returns a superset of the objects matching q, as CfgMap.Search does it, or false if the
+// index can't help
+func (idx *Index) searchCandidates(q *CfgQuery) (UUIDs, bool) {
+	if len(q.Keys) == 0 || !q.Balanced() {
+		return nil, false
+	}
+	var best UUIDs
+	found := false
+	for i := range q.Keys {
+		c, ok := idx.Matching(q.Keys[i], q.RXs[i])
+		if ok && (!found || len(c) < len(best)) {
+			best, found = c, true
+		}
+	}
+	return best, found
+}
+
+// CommandUsers returns the objects using the given command in check_command, event_handler or the
+// notification commands
+func (idx *Index) CommandUsers(cmd string) UUIDs {
+	return idx.lookup(idxCmd + cmd)
+}
+
+// isIndexKey returns true if the values of key are indexed
+func isIndexKey(key string) bool {
+	for _, k := range INDEX_KEYS {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// candidates returns a superset of the objects that can match n, or false if the index can't help
+func (idx *Index) candidates(n qnode) (UUIDs, bool) {
+	switch n := n.(type) {
+	case qCmp:
+		if n.op != "=" {
+			return nil, false
+		}
+		if n.key == Q_KEY_TYPE {
+			cn := CfgName(n.val)
+			if !cn.Valid() {
+				return UUIDs{}, true
+			}
+			return idx.ByType(cn.Type()), true
+		}
+		if isIndexKey(n.key) {
+			// equal values give equal lists, so the first item is enough to find them
+			return idx.ByValue(n.key, splitList(n.val)[0]), true
+		}
+	case qAnd:
+		// the smallest set of candidates from any part will do
+		var best UUIDs
+		found := false
+		for i := range n {
+			c, ok := idx.candidates(n[i])
+			if ok && (!found || len(c) < len(best)) {
+				best, found = c, true
+			}
+		}
+		return best, found
+	case qOr:
+		// all parts must be covered by the index
+		var es UUIDs
+		seen := make(uuidSet)
+		for i := range n {
+			c, ok := idx.candidates(n[i])
+			if !ok {
+				return nil, false
+			}
+			for _, u := range c {
+				if _, found := seen[u]; !found {
+					seen[u] = struct{}{}
+					es = append(es, u)
+				}
+			}
+		}
+		sort.Slice(es, func(i, j int) bool {
+			return idx.pos[es[i]] < idx.pos[es[j]]
+		})
+		return es, true
+	}
+	return nil, false
+}
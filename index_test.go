@@ -0,0 +1,210 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"testing"
+)
+
+var indexcfgstr string = `define host{
+	host_name web01
+	hostgroups webservers,linux
+}
+define host{
+	host_name web02
+}
+define hostgroup{
+	hostgroup_name webservers
+	members web02
+}
+define service{
+	host_name web01,web02
+	service_description HTTP
+	check_command check_http!-p 80
+}
+define service{
+	host_name web01
+	service_description PING
+	check_command check_ping!100.0,20%!500.0,60%
+	event_handler restart_http
+}
+define command{
+	command_name check_http
+	command_line /bin/true
+}
+`
+
+// hostNames returns the host_name of each object, for easy comparison
+func hostNames(cm CfgMap, ids UUIDs) []string {
+	names := make([]string, len(ids))
+	for i := range ids {
+		names[i] = cm[ids[i]].Props["host_name"]
+	}
+	return names
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestIndex(t *testing.T) {
+	nc := loadDiffCfg(t, indexcfgstr)
+	idx := nc.EnableIndex()
+	defer nc.DisableIndex()
+
+	if got := hostNames(nc.Config, idx.ByType(T_HOST)); !sameStrings(got, []string{"web01", "web02"}) {
+		t.Errorf("ByType: got %v", got)
+	}
+	if got := hostNames(nc.Config, idx.ServicesOnHost("web02")); !sameStrings(got, []string{"web01,web02"}) {
+		t.Errorf("ServicesOnHost: got %v", got)
+	}
+	if got := hostNames(nc.Config, idx.HostgroupMembers("webservers")); !sameStrings(got, []string{"web01", "web02"}) {
+		t.Errorf("HostgroupMembers: got %v", got)
+	}
+	if len(idx.CommandUsers("check_http")) != 1 || len(idx.CommandUsers("restart_http")) != 1 {
+		t.Error("CommandUsers did not find the services")
+	}
+
+	// changes through the API are seen
+	web02 := nc.Config[idx.ByIdentity(T_HOST, "web02")[0]]
+	web02.Set("host_name", "web03")
+	if len(idx.ByIdentity(T_HOST, "web02")) != 0 || len(idx.ByIdentity(T_HOST, "web03")) != 1 {
+		t.Error("Index not updated on Set")
+	}
+	nc.matches = idx.CommandUsers("check_ping")
+	nc.DeleteMatches()
+	if len(idx.ServicesOnHost("web01")) != 1 || len(idx.CommandUsers("restart_http")) != 0 {
+		t.Error("Index not updated on delete")
+	}
+	co := NewCfgObjWithUUID(T_HOST)
+	co.Add("host_name", "db01")
+	nc.Config.AddByUUID(co.UUID, co)
+	if got := hostNames(nc.Config, nc.Config.FilterType(T_HOST)); !sameStrings(got, []string{"web01", "web03", "db01"}) {
+		t.Errorf("FilterType via index: got %v", got)
+	}
+
+	// changes behind its back are caught by the count
+	delete(nc.Config, co.UUID)
+	if len(idx.ByType(T_HOST)) != 2 {
+		t.Error("Index not rebuilt after direct map change")
+	}
+}
+
+func TestIndexSearchQuery(t *testing.T) {
+	nc := loadDiffCfg(t, indexcfgstr)
+	queries := []string{
+		`type=service AND host_name=web01`,
+		`host_name="web01,web02" OR type=command`,
+		`hostgroups=linux AND NOT EXISTS parents`,
+		`check_command=~http`,
+	}
+	var plain [][]string
+	for _, s := range queries {
+		plain = append(plain, hostNames(nc.Config, nc.Config.SearchQuery(MustParseQuery(s))))
+	}
+	idx := nc.EnableIndex()
+	defer nc.DisableIndex()
+	for i, s := range queries {
+		q := MustParseQuery(s)
+		if got := hostNames(nc.Config, nc.Config.SearchQuery(q)); !sameStrings(got, plain[i]) {
+			t.Errorf("%q: expected %v, got %v", s, plain[i], got)
+		}
+	}
+	if _, ok := idx.candidates(MustParseQuery(`host_name=web01 OR notes=x`).root); ok {
+		t.Error("OR with an unindexed key should not use the index")
+	}
+	if c, ok := idx.candidates(MustParseQuery(`type=command AND host_name=web02`).root); !ok || len(c) != 1 {
+		t.Errorf("Expected the smallest set of candidates, got %d", len(c))
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	nc := loadDiffCfg(t, indexcfgstr)
+	queries := [][]string{
+		{"host_name", "^web01"},
+		{"host_name", "web02$", "service_description", "HTTP"},
+		{"hostgroups", "linux", "notes", "."},
+		{"host_name", "^nowhere$"},
+	}
+	newQuery := func(kv []string) *CfgQuery {
+		q := NewCfgQuery()
+		for i := 0; i < len(kv); i += 2 {
+			q.AddKeyRX(kv[i], kv[i+1])
+		}
+		return q
+	}
+	var plain [][]string
+	for _, kv := range queries {
+		plain = append(plain, hostNames(nc.Config, nc.Config.Search(newQuery(kv))))
+	}
+	var plainSub [][]string
+	for _, kv := range queries {
+		plainSub = append(plainSub, hostNames(nc.Config, nc.Config.SearchSubSet(newQuery(kv), nil)))
+	}
+	idx := nc.EnableIndex()
+	defer nc.DisableIndex()
+	for i, kv := range queries {
+		if got := hostNames(nc.Config, nc.Config.Search(newQuery(kv))); !sameStrings(got, plain[i]) {
+			t.Errorf("%v: expected %v, got %v", kv, plain[i], got)
+		}
+		// no subset means the whole map, with or without an index
+		if got := hostNames(nc.Config, nc.Config.SearchSubSet(newQuery(kv), nil)); !sameStrings(got, plainSub[i]) {
+			t.Errorf("%v: expected %v from SearchSubSet, got %v", kv, plainSub[i], got)
+		}
+	}
+	if c, ok := idx.searchCandidates(newQuery(queries[1])); !ok || len(c) != 2 {
+		t.Errorf("Expected 2 candidates from the index, got %d", len(c))
+	}
+	if _, ok := idx.searchCandidates(newQuery([]string{"notes", "."})); ok {
+		t.Error("Unindexed keys should not use the index")
+	}
+
+	// the index follows the config when it's replaced
+	nc.Config = loadDiffCfg(t, querycfgstr).Config
+	if idx2 := nc.Index(); idx2 == idx || len(idx2.ByType(T_HOST)) != 1 || indexOf(nc.Config) != idx2 {
+		t.Error("Expected a new index for the new config")
+	}
+}
+
+func TestIndexAddFromObservedMap(t *testing.T) {
+	a := loadDiffCfg(t, indexcfgstr)
+	b := NewNagiosCfg()
+	idxA := a.EnableIndex()
+	defer a.DisableIndex()
+	b.EnableIndex()
+	defer b.DisableIndex()
+
+	if err := b.Config.Append(a.Config); err != nil {
+		t.Fatal(err)
+	}
+	u := a.Config.FilterType(T_HOST)[0]
+	if b.Config[u] == a.Config[u] {
+		t.Error("Expected a copy of an object in another observed map")
+	}
+	a.Config[u].Set("hostgroups", "moved")
+	if got := idxA.ByValue("hostgroups", "moved"); len(got) != 1 || got[0] != u {
+		t.Errorf("The index of the first map should still see changes, got %v", got)
+	}
+}
@@ -32,7 +32,6 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 	"sync"
 	"unicode"
@@ -58,7 +57,10 @@ var (
 )
 
 type Reader struct {
-	Comment   rune
+	Comment     rune
+	StableUUIDs bool // derive UUIDs from type and identity instead of generating new ones, see NewUUIDv5
+	namer       *uuidNamer
+	comments    []string // comment lines read since the last object
 	line      int
 	inputline int // separate counter that should match the line number from input
 	column    int
@@ -73,6 +75,12 @@ type FileReader struct {
 
 type MultiFileReader []*FileReader
 
+// uuidNamer keeps track of names used for stable UUIDs, so that duplicates get unique IDs
+type uuidNamer struct {
+	sync.Mutex
+	seen map[string]int
+}
+
 func _debug(args ...interface{}) {
 	fmt.Println(args)
 }
@@ -107,6 +115,34 @@ func NewMultiFileReader(paths ...string) MultiFileReader {
 	return mfr
 }
 
+// SetStableUUIDs turns on or off stable UUIDs for all files, with duplicates counted across files
+func (mfr MultiFileReader) SetStableUUIDs(enable bool) {
+	n := newUUIDNamer()
+	for i := range mfr {
+		mfr[i].StableUUIDs = enable
+		mfr[i].namer = n
+	}
+}
+
+func newUUIDNamer() *uuidNamer {
+	return &uuidNamer{seen: make(map[string]int)}
+}
+
+// stableUUID returns a name based UUID for co, from its type and identity, or from all its properties if it
+// has no identity. The second object with the same name gets "#2" appended to the name, and so on, so IDs for
+// duplicates depend on the order they are read in.
+func (n *uuidNamer) stableUUID(co *CfgObj) UUID {
+	name := co.Type.String() + "/" + diffID(co)
+	n.Lock()
+	n.seen[name]++
+	cnt := n.seen[name]
+	n.Unlock()
+	if cnt > 1 {
+		name = fmt.Sprintf("%s#%d", name, cnt)
+	}
+	return NewUUIDv5(NAMESPACE_NAGIOSCFG, name)
+}
+
 func (fr *FileReader) Close() error {
 	return fr.f.Close()
 }
@@ -177,6 +213,42 @@ func (r *Reader) skip(delim rune) error {
 	}
 }
 
+// readComment reads the rest of the line as a comment, to be kept with the next object
+func (r *Reader) readComment() error {
+	r.field.Reset()
+	for {
+		r1, err := r.readRune()
+		if err != nil || r1 == '\n' {
+			r.comments = append(r.comments, strings.TrimRightFunc(r.field.String(), unicode.IsSpace))
+			if err == io.EOF {
+				return nil // let the next read find it
+			}
+			return err
+		}
+		r.field.WriteRune(r1)
+	}
+}
+
+// Comments returns the comment lines read since the last object was returned by Read. After Read returns
+// io.EOF, these are the comments at the end of the input.
+func (r *Reader) Comments() []string {
+	return r.comments
+}
+
+// takeComments sets the comment lines read so far as the comment of co
+func (r *Reader) takeComments(co *CfgObj) {
+	if len(r.comments) == 0 || co == nil {
+		return
+	}
+	if co.keepCmt {
+		co.Comment += "\n" + strings.Join(r.comments, "\n")
+	} else {
+		co.Comment = strings.Join(r.comments, "\n")
+		co.keepCmt = true
+	}
+	r.comments = nil
+}
+
 func (r *Reader) parseFields() (haveField bool, delim rune, err error) {
 	r.field.Reset() // clear buffer at each call
 
@@ -262,7 +334,8 @@ func (r *Reader) parseLine() (fields []string, state IoState, err error) {
 		return nil, IO_OBJ_OUT, err
 	}
 	if r.Comment != 0 && r1 == r.Comment {
-		return nil, IO_OBJ_OUT, r.skip('\n')
+		r.r.UnreadRune()
+		return nil, IO_OBJ_OUT, r.readComment()
 	}
 	r.r.UnreadRune()
 
@@ -314,20 +387,26 @@ func (r *Reader) Read(setUUID bool, fileID string) (*CfgObj, error) {
 					log.Debugf("Invalid type (f#1): %q, Err: %q %s", fields, err, dbgStr(false))
 					return nil, r.error(ErrUnknown)
 				}
-				if setUUID {
+				if setUUID && !r.StableUUIDs {
 					co = NewCfgObjWithUUID(ct)
-					uuidorder = append(uuidorder, co.UUID) // keep track of original order of objects read
+					addOrder(co.UUID) // keep track of original order of objects read
 				} else {
 					co = NewCfgObj(ct)
 				}
 				if fileID != "" {
 					co.FileID = fileID
 				}
+				r.takeComments(co) // the comment lines before the definition
 				prevState = IO_OBJ_BEGIN
 			case IO_OBJ_IN:
 				//prevState = IO_OBJ_IN
 				fl := len(fields)
 				//_debug(fields)
+				if co != nil && r.Comment != 0 && strings.HasPrefix(fields[0], string(r.Comment)) {
+					// indented comment inside the definition, kept with the comment before it
+					r.comments = append(r.comments, strings.Join(fields, " "))
+					continue
+				}
 				if fl < 2 || co == nil {
 					//return nil, r.error(ErrNoValue)
 					log.Debugf("Too few fields (#%d): %#v %s", fl, fields, dbgStr(false))
@@ -336,6 +415,15 @@ func (r *Reader) Read(setUUID bool, fileID string) (*CfgObj, error) {
 				//log.Debugf("%q %q", fields[0], strings.Join(fields[1:fl], " "))
 				co.Add(fields[0], strings.Join(fields[1:fl], " "))
 			case IO_OBJ_END:
+				r.takeComments(co) // comments inside the definition are moved before it
+				if setUUID && r.StableUUIDs && co != nil {
+					// the identity is not known until all props are read
+					if r.namer == nil {
+						r.namer = newUUIDNamer()
+					}
+					co.UUID = r.namer.stableUUID(co)
+					addOrder(co.UUID)
+				}
 				//fmt.Printf("Obj size: %d\n", co.size()) // approx avg turned out to be ~362 bytes per declaration for our services.cfg file
 				return co, nil
 			default:
@@ -480,104 +568,131 @@ func (mfr MultiFileReader) ReadAllMap() (CfgMap, error) {
 	return cm, nil
 }
 
+// Format reads all object definitions in src and returns them in canonical form, like gofmt does for Go source.
+// Properties are sorted, values aligned on the longest key (but never closer than DEF_ALIGN, as the reader relies
+// on that to tell a '{' in a value from the start of a new definition). The comment lines before each definition
+// are kept, and so are those at the end of src. Comments inside a definition are moved before it. Objects without
+// a comment get a generated one.
+func Format(src []byte) ([]byte, error) {
+	rdr := NewReader(bytes.NewReader(src))
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.AutoAlign = true
+	for {
+		co, err := rdr.Read(false, "")
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if co == nil {
+			continue
+		}
+		err = w.Write(co)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range rdr.Comments() {
+		fmt.Fprintf(w.w, "%s\n", c)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
 // PrintProps prints a CfgObj's properties in random order
-func (co *CfgObj) PrintProps(w io.Writer, format string) {
+func (co *CfgObj) PrintProps(w io.Writer, format string) error {
 	for k, v := range co.Props {
-		fmt.Fprintf(w, format, k, v)
+		_, err := fmt.Fprintf(w, format, k, v)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // PrintPropsSorted prints a CfgObj's properties acording to sort order found here:
 // https://assets.nagios.com/downloads/nagioscore/docs/nagioscore/3/en/objectdefinitions.html
-func (co *CfgObj) PrintPropsSorted(w io.Writer, format string) {
-	keypri := make(map[int]string)
-	for k := range co.Props {
-		keypri[CfgKeySortOrder[k][co.Type]] = k // should have error checking for non-existing keys/types
-	}
-	keys := make([]int, len(keypri))
-	i := 0
-	for k := range keypri {
-		keys[i] = k
-		i++
-	}
-	sort.Ints(keys)
-	for _, k := range keys {
-		fmt.Fprintf(w, format, keypri[k], co.Props[keypri[k]])
+// Keys without a defined order are printed last, alphabetically. See SortedKeys.
+func (co *CfgObj) PrintPropsSorted(w io.Writer, format string) error {
+	for _, k := range co.SortedKeys() {
+		_, err := fmt.Fprintf(w, format, k, co.Props[k])
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // Print prints out a CfgObj in Nagios format
-func (co *CfgObj) Print(w io.Writer, sorted bool) {
-	prefix := strings.Repeat(" ", co.Indent)
-	fstr := fmt.Sprintf("%s%s%d%s", prefix, "%-", co.Align, "s%s\n")
-	co.generateComment() // this might fail, but don't care yet
-	fmt.Fprintf(w, "%s\n", co.Comment)
-	fmt.Fprintf(w, "define %s{\n", co.Type.String())
-	if sorted {
-		co.PrintPropsSorted(w, fstr)
-	} else {
-		co.PrintProps(w, fstr)
+func (co *CfgObj) Print(w io.Writer, sorted bool) error {
+	ow := NewWriter(w)
+	ow.Sorted = sorted
+	ow.Separate = false
+	ow.Write(co)
+	ow.Flush()
+	return ow.Error()
+}
+
+// writeUUIDs writes the objects with the given IDs, with a blank line after each, skipping IDs not in cm
+func (cm CfgMap) writeUUIDs(w io.Writer, u UUIDs, sorted bool) error {
+	ow := NewWriter(w)
+	ow.Sorted = sorted
+	for _, v := range u {
+		obj, ok := cm.GetByUUID(v)
+		if ok && obj != nil {
+			err := ow.Write(obj)
+			if err != nil {
+				return err
+			}
+		}
 	}
-	fmt.Fprintf(w, "%s}\n", prefix)
+	ow.Flush()
+	return ow.Error()
 }
 
 // Print writes a collection of CfgObj to a given stream
-func (cos CfgObjs) Print(w io.Writer, sorted bool) {
-	for i := range cos {
-		cos[i].Print(w, sorted)
-		fmt.Fprint(w, "\n")
-	}
+func (cos CfgObjs) Print(w io.Writer, sorted bool) error {
+	ow := NewWriter(w)
+	ow.Sorted = sorted
+	return ow.WriteAll(cos)
 }
 
-func (cm CfgMap) Print(w io.Writer, sorted bool) {
+func (cm CfgMap) Print(w io.Writer, sorted bool) error {
 	if sorted {
-		keys := cm.Keys()
-		for i := range keys {
-			cm[keys[i]].Print(w, sorted)
-			fmt.Fprintf(w, "\n")
-		}
-	} else {
-		for k := range cm {
-			cm[k].Print(w, sorted)
-			fmt.Fprintf(w, "\n")
-		}
+		return cm.writeUUIDs(w, cm.Keys(), sorted)
 	}
+	keys := make(UUIDs, 0, len(cm))
+	for k := range cm {
+		keys = append(keys, k)
+	}
+	return cm.writeUUIDs(w, keys, sorted)
 }
 
-func (cm CfgMap) PrintUUIDs(w io.Writer, u UUIDs, sorted bool) {
-	for _, v := range u {
-		obj, ok := cm.GetByUUID(v)
-		if ok && obj != nil {
-			obj.Print(w, sorted)
-			fmt.Fprintf(w, "\n")
-		}
-	}
+func (cm CfgMap) PrintUUIDs(w io.Writer, u UUIDs, sorted bool) error {
+	return cm.writeUUIDs(w, u, sorted)
 }
 
-func (nc *NagiosCfg) Print(w io.Writer, sorted bool) {
-	nc.Config.Print(w, sorted)
+func (nc *NagiosCfg) Print(w io.Writer, sorted bool) error {
+	return nc.Config.Print(w, sorted)
 }
 
-func (nc *NagiosCfg) PrintUUIDs(w io.Writer, u UUIDs, sorted bool) {
-	nc.Config.PrintUUIDs(w, u, sorted)
+func (nc *NagiosCfg) PrintUUIDs(w io.Writer, u UUIDs, sorted bool) error {
+	return nc.Config.PrintUUIDs(w, u, sorted)
 }
 
-func (nc *NagiosCfg) PrintMatches(w io.Writer, sorted bool) {
+func (nc *NagiosCfg) PrintMatches(w io.Writer, sorted bool) error {
 	if nc.matches == nil || len(nc.matches) == 0 {
-		return
+		return nil
 	}
 	// I'd like original ordering here as well
-	for i := range nc.matches {
-		nc.Config[nc.matches[i]].Print(w, sorted)
-		fmt.Fprintf(w, "\n")
-	}
+	return nc.Config.writeUUIDs(w, nc.matches, sorted)
 }
 
 func (nc *NagiosCfg) DumpString() string {
 	var buf bytes.Buffer
-	w := bufio.NewWriter(&buf)
-	nc.Print(w, true)
-	w.Flush()
+	nc.Print(&buf, true)
 	return buf.String()
 }
 
@@ -594,13 +709,12 @@ func (cm CfgMap) WriteFile(filename string, sort bool) error {
 	if err != nil {
 		return err
 	}
-	defer fhnd.Close()
-	w := bufio.NewWriter(fhnd)
-	for k := range cm {
-		cm[k].Print(w, sort)
+	err = cm.Print(fhnd, sort)
+	cerr := fhnd.Close()
+	if err != nil {
+		return err
 	}
-	w.Flush()
-	return nil
+	return cerr
 }
 
 func (cm CfgMap) WriteByFileID(sort bool) error {
@@ -627,14 +741,16 @@ func (cm CfgMap) WriteByFileID(sort bool) error {
 				schan <- err
 				return
 			}
-			defer fhnd.Close()
-			w := bufio.NewWriter(fhnd)
-			for i := range fmap[filename] {
-				cm[fmap[filename][i]].Print(w, sort)
-				fmt.Fprintf(w, "\n") // add extra blank line between each object
+			// Separate adds an extra blank line between each object
+			err = cm.writeUUIDs(fhnd, fmap[filename], sort)
+			cerr := fhnd.Close()
+			if err == nil {
+				err = cerr
+			}
+			if err != nil {
+				err = fmt.Errorf("%s: %s", filename, err)
 			}
-			w.Flush()
-			schan <- nil
+			schan <- err
 		}(fname)
 	}
 
@@ -17,9 +17,11 @@
 package nagioscfg
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -76,6 +78,34 @@ func TestReadAllMap(t *testing.T) {
 	}
 }
 
+func TestReadStableUUIDs(t *testing.T) {
+	read := func() UUIDs {
+		rdr := NewReader(strings.NewReader(identitycfgstr))
+		rdr.StableUUIDs = true
+		m, err := rdr.ReadAllMap("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Len() != 9 {
+			t.Fatalf("Expected 9 objects, got %d", m.Len())
+		}
+		return m.Keys()
+	}
+	k1 := read()
+	k2 := read()
+	for i := range k1 {
+		if !k1[i].Equals(k2[i]) {
+			t.Errorf("Expected same UUID for object #%d on reload, got %s and %s", i, k1[i], k2[i])
+		}
+	}
+	if !k1[0].Equals(NewUUIDv5(NAMESPACE_NAGIOSCFG, "host/name=generic-host")) {
+		t.Errorf("Unexpected UUID for template: %s", k1[0])
+	}
+	if !k1[2].Equals(NewUUIDv5(NAMESPACE_NAGIOSCFG, "host/web01#2")) {
+		t.Errorf("Unexpected UUID for duplicate: %s", k1[2])
+	}
+}
+
 // Test how we can use UUID as a map key and use the string representation back and forth to retrieve the entry
 func TestUUIDMapKeys(t *testing.T) {
 	str_r := strings.NewReader(cfgobjstr)
@@ -419,5 +449,211 @@ func TestNcfgMarshalJSON(t *testing.T) {
 }
 
 func TestNcfgUnmarshalJSON(t *testing.T) {
-	//jbytes := []byte(`{"sessionid":"02e67b59-7193-11e7-82f9-0800279d8583","date":"2017-07-26T01:43:08.08799836+02:00","version":"2017-07-26","cfg":{"02e67853-7193-11e7-82f9-0800279d8583":{"uuid":"02e67853-7193-11e7-82f9-0800279d8583","fileid":"../op5_automation/cfg/etc/services-mini.cfg","type":8,"props":{"check_command":"check_snmpif_traffic_v2!wcar_supervision!224!1000mbit!70!90","servicegroups":"VGT_Infrastructure_Services","use":"linux-prod","host_name":"vgt-cn-sha-lb-02","service_description":"Interface 224 Traffic"}},"02e678f5-7193-11e7-82f9-0800279d8583":{"uuid":"02e678f5-7193-11e7-82f9-0800279d8583","fileid":"../op5_automation/cfg/etc/services-mini.cfg","type":8,"props":{"use":"linux-prod","host_name":"vgt-cn-sha-lb-02","service_description":"PING","check_command":"check_ping!100,20%!500,60%","servicegroups":"VGT_Infrastructure_Services"}},"02e67951-7193-11e7-82f9-0800279d8583":{"uuid":"02e67951-7193-11e7-82f9-0800279d8583","fileid":"../op5_automation/cfg/etc/services-mini.cfg","type":8,"props":{"check_command":"vgt_check_f5_psu!wcar_supervision!5","servicegroups":"PROD_VOC_CN_Services,VGT_Infrastructure_Services","contact_groups":"wcar_jour_got_sms,wcar_network","use":"linux-prod","host_name":"vgt-cn-sha-lb-02","service_description":"PSU Status"}}}}`)
+	// document from before the type was written by name
+	jbytes := []byte(`{"sessionid":"02e67b59-7193-11e7-82f9-0800279d8583","date":"2017-07-26T01:43:08.08799836+02:00","version":"2017-07-26","cfg":{"02e67853-7193-11e7-82f9-0800279d8583":{"uuid":"02e67853-7193-11e7-82f9-0800279d8583","fileid":"../op5_automation/cfg/etc/services-mini.cfg","type":8,"props":{"check_command":"check_snmpif_traffic_v2!wcar_supervision!224!1000mbit!70!90","servicegroups":"VGT_Infrastructure_Services","use":"linux-prod","host_name":"vgt-cn-sha-lb-02","service_description":"Interface 224 Traffic"}},"02e678f5-7193-11e7-82f9-0800279d8583":{"uuid":"02e678f5-7193-11e7-82f9-0800279d8583","fileid":"../op5_automation/cfg/etc/services-mini.cfg","type":8,"props":{"use":"linux-prod","host_name":"vgt-cn-sha-lb-02","service_description":"PING","check_command":"check_ping!100,20%!500,60%","servicegroups":"VGT_Infrastructure_Services"}},"02e67951-7193-11e7-82f9-0800279d8583":{"uuid":"02e67951-7193-11e7-82f9-0800279d8583","fileid":"../op5_automation/cfg/etc/services-mini.cfg","type":8,"props":{"check_command":"vgt_check_f5_psu!wcar_supervision!5","servicegroups":"PROD_VOC_CN_Services,VGT_Infrastructure_Services","contact_groups":"wcar_jour_got_sms,wcar_network","use":"linux-prod","host_name":"vgt-cn-sha-lb-02","service_description":"PSU Status"}}}}`)
+	exp := UUIDs{}
+	for _, s := range []string{
+		"02e67853-7193-11e7-82f9-0800279d8583",
+		"02e678f5-7193-11e7-82f9-0800279d8583",
+		"02e67951-7193-11e7-82f9-0800279d8583",
+	} {
+		u, _ := UUIDFromString(s)
+		exp = append(exp, u)
+	}
+
+	ncfg := &NagiosCfg{}
+	err := ncfg.UnmarshalJSON(jbytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ncfg.SessionID.String() != "02e67b59-7193-11e7-82f9-0800279d8583" {
+		t.Errorf("Wrong session ID: %s", ncfg.SessionID)
+	}
+	if ncfg.Len() != len(exp) {
+		t.Fatalf("Expected %d objects, got %d", len(exp), ncfg.Len())
+	}
+	keys := ncfg.Config.Keys()
+	for i := range exp {
+		if !keys[i].Equals(exp[i]) {
+			t.Errorf("Object #%d: expected %s, got %s", i, exp[i], keys[i])
+		}
+		o := ncfg.Config[exp[i]]
+		if o.Type != T_SERVICE {
+			t.Errorf("Object %s: expected type %s, got %s", exp[i], T_SERVICE, o.Type)
+		}
+		if o.UUID != exp[i] {
+			t.Errorf("Object UUID %s does not match key %s", o.UUID, exp[i])
+		}
+	}
+	desc, _ := ncfg.Config[exp[1]].GetDescription()
+	if desc != "PING" {
+		t.Errorf("Expected service_description %q, got %q", "PING", desc)
+	}
+}
+
+func TestNcfgJSONRoundTrip(t *testing.T) {
+	rdr := NewReader(strings.NewReader(cfgobjstr))
+	cm, err := rdr.ReadAllMap("/dev/null")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc1 := NewNagiosCfg()
+	nc1.Config = cm
+
+	jbuf, err := json.Marshal(nc1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(jbuf), `"type":"command"`) {
+		t.Errorf("Expected type to be written by name: %s", jbuf)
+	}
+
+	nc2 := &NagiosCfg{}
+	err = json.Unmarshal(jbuf, nc2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nc1.SessionID.Equals(nc2.SessionID) {
+		t.Errorf("Session ID %s != %s", nc1.SessionID, nc2.SessionID)
+	}
+	k1 := nc1.Config.Keys()
+	k2 := nc2.Config.Keys()
+	if len(k1) != len(k2) {
+		t.Fatalf("Expected %d objects, got %d", len(k1), len(k2))
+	}
+	for i := range k1 {
+		if !k1[i].Equals(k2[i]) {
+			t.Errorf("Order differs at #%d: %s != %s", i, k1[i], k2[i])
+		}
+		o1, o2 := nc1.Config[k1[i]], nc2.Config[k1[i]]
+		if o1.Type != o2.Type || o1.FileID != o2.FileID || !reflect.DeepEqual(o1.Props, o2.Props) {
+			t.Errorf("Objects differ:\n%+v\n%+v", o1, o2)
+		}
+	}
+
+	jbuf2, err := json.Marshal(nc2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// everything but the date should be identical
+	strip := func(b []byte) string {
+		s := string(b)
+		i := strings.Index(s, `"date":`)
+		j := strings.Index(s, `"version":`)
+		return s[:i] + s[j:]
+	}
+	if strip(jbuf) != strip(jbuf2) {
+		t.Errorf("JSON differs after round-trip:\n%s\n%s", jbuf, jbuf2)
+	}
+
+	// the order read above is in the shared uuidorder already, so to see that decoding keeps the order of the
+	// document, use UUIDs never seen before, in the opposite order
+	fresh := make(UUIDs, len(k1))
+	parts := make([]string, len(k1))
+	for i := range k1 {
+		fresh[i] = NewUUIDv1()
+		jo, err := json.Marshal(nc1.Config[k1[len(k1)-1-i]])
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[i] = fmt.Sprintf("%q:%s", fresh[i].String(), jo)
+	}
+	nc3 := &NagiosCfg{}
+	err = json.Unmarshal([]byte(`{"cfg":{`+strings.Join(parts, ",")+`}}`), nc3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k3 := nc3.Config.Keys()
+	if len(k3) != len(fresh) {
+		t.Fatalf("Expected %d objects, got %d", len(fresh), len(k3))
+	}
+	for i := range fresh {
+		if !k3[i].Equals(fresh[i]) {
+			t.Errorf("Order not kept from document at #%d: %s != %s", i, fresh[i], k3[i])
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	src := []byte(`define service{
+host_name   web01
+	service_description HTTP
+  use generic-service
+}
+define hostdependency {
+	host_name web01
+	dependent_host_name web02
+}
+`)
+	exp := `# service 'HTTP'
+define service{
+    use                            generic-service
+    host_name                      web01
+    service_description            HTTP
+    }
+
+# hostdependency
+define hostdependency{
+    dependent_host_name            web02
+    host_name                      web01
+    }
+
+`
+	res, err := Format(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != exp {
+		t.Errorf("Expected:\n%s\nGot:\n%s", exp, res)
+	}
+
+	// formatting formatted output should not change anything
+	res2, err := Format(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res2) != string(res) {
+		t.Errorf("Format is not idempotent, got:\n%s", res2)
+	}
+}
+
+func TestFormatComments(t *testing.T) {
+	src := `# my important comment
+# 100% sure
+define host{
+    host_name                      web01
+    }
+
+define service{
+    host_name                      web01
+    # inside
+    service_description            HTTP
+    }
+
+# the end
+`
+	exp := `# my important comment
+# 100% sure
+define host{
+    host_name                      web01
+    }
+
+# inside
+define service{
+    host_name                      web01
+    service_description            HTTP
+    }
+
+# the end
+`
+	res, err := Format([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != exp {
+		t.Errorf("Expected:\n%s\nGot:\n%s", exp, res)
+	}
+	if res2, _ := Format(res); string(res2) != exp {
+		t.Errorf("Formatted output with comments should not change, got:\n%s", res2)
+	}
 }
@@ -0,0 +1,398 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+Undo/redo journal for in-memory edits.
+Once enabled with NagiosCfg.EnableJournal, every change made through CfgObj.Set/Del, CfgMap.Add/Set/Del and
+the NagiosCfg methods built on them is recorded (see hooks.go). Batch operations like NagiosCfg.SetKeys or
+Patch.Apply are recorded as one group, which Undo and Redo treat as a single step.
+The journal can be exported as JSON, to see what a batch job did: the changes that can be undone and redone,
+and the full history, with every change in the order it happened, including undo and redo of earlier changes.
+Added and removed objects are exported with their properties at the time of the change.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type JournalOp int
+
+const (
+	J_SET    JournalOp = iota // property set, Old is "" if it did not exist before
+	J_DEL                     // property deleted
+	J_ADD                     // object added
+	J_REMOVE                  // object removed
+)
+
+var journalOpNames = [...]string{
+	"set",
+	"del",
+	"add",
+	"remove",
+}
+
+const (
+	J_REPLAY_UNDO string = "undo" // in the history, for an entry being undone
+	J_REPLAY_REDO string = "redo" // in the history, for an entry being redone
+)
+
+var (
+	ErrNothingToUndo = errors.New("nothing to undo")
+	ErrNothingToRedo = errors.New("nothing to redo")
+)
+
+// JournalEntry is a single recorded change. Key, Old and New are only set for J_SET and J_DEL, and Props only
+// for J_ADD and J_REMOVE.
+type JournalEntry struct {
+	Op     JournalOp
+	Time   time.Time
+	Batch  int    // entries with the same non-zero Batch belong together
+	Desc   string // description of the batch
+	UUID   UUID
+	Type   CfgType
+	Key    string
+	Old    string
+	New    string
+	Props  map[string]string // copy of the object's properties when added or removed
+	Replay string            // J_REPLAY_UNDO or J_REPLAY_REDO for undo and redo in the history, otherwise ""
+	obj    *CfgObj
+}
+
+type Journal struct {
+	cm          CfgMap
+	done        [][]JournalEntry // groups of entries that are undone together
+	undone      [][]JournalEntry
+	history     []JournalEntry // everything, in order, including undo and redo
+	checkpoints map[string]int // label => number of groups in done at the time
+	batch       int            // id of the current batch, 0 if none
+	batchDesc   string
+	batchDepth  int // batches can be nested, but only the outermost counts
+	batchCnt    int
+	replaying   bool // true while undoing or redoing, so that we don't record that as well
+}
+
+func (op JournalOp) String() string {
+	return journalOpNames[op]
+}
+
+// NewJournal returns a journal recording all changes to cm, until Close is called
+func NewJournal(cm CfgMap) *Journal {
+	j := &Journal{
+		cm:          cm,
+		checkpoints: make(map[string]int),
+	}
+	attachObserver(cm, j)
+	return j
+}
+
+// Close stops recording. The journal can still be exported, but not used for undo/redo.
+func (j *Journal) Close() {
+	detachObserver(j.cm, j)
+	j.cm = nil
+}
+
+// EnableJournal starts recording changes to the config, returning the journal. If already enabled, the
+// existing journal is returned.
+func (nc *NagiosCfg) EnableJournal() *Journal {
+	if nc.journal == nil {
+		nc.journal = NewJournal(nc.Config)
+		nc.own(nc.journal)
+	}
+	return nc.journal
+}
+
+// DisableJournal stops recording changes to the config, and drops the journal
+func (nc *NagiosCfg) DisableJournal() {
+	if nc.journal != nil {
+		nc.journal.Close()
+		nc.disown(nc.journal)
+		nc.journal = nil
+	}
+}
+
+// Journal returns the journal for the config, or nil if not enabled
+func (nc *NagiosCfg) Journal() *Journal {
+	return nc.journal
+}
+
+func (j *Journal) record(e JournalEntry) {
+	if j.replaying {
+		return
+	}
+	e.Time = time.Now()
+	e.Batch = j.batch
+	e.Desc = j.batchDesc
+	if e.obj != nil {
+		e.UUID = e.obj.UUID
+		e.Type = e.obj.Type
+	}
+	if e.Op == J_ADD || e.Op == J_REMOVE {
+		e.Props = make(map[string]string, len(e.obj.Props))
+		for k, v := range e.obj.Props {
+			e.Props[k] = v
+		}
+	}
+	j.history = append(j.history, e)
+
+	// a new change makes everything undone impossible to redo, and checkpoints after this point invalid
+	j.undone = nil
+	for label, pos := range j.checkpoints {
+		if pos > len(j.done) {
+			delete(j.checkpoints, label)
+		}
+	}
+
+	if j.batch != 0 && len(j.done) > 0 {
+		last := j.done[len(j.done)-1]
+		if last[0].Batch == j.batch {
+			j.done[len(j.done)-1] = append(last, e)
+			return
+		}
+	}
+	j.done = append(j.done, []JournalEntry{e})
+}
+
+func (j *Journal) propChanged(co *CfgObj, key, oldVal, newVal string) {
+	op := J_SET
+	if newVal == "" {
+		op = J_DEL
+	}
+	j.record(JournalEntry{Op: op, Key: key, Old: oldVal, New: newVal, obj: co})
+}
+
+func (j *Journal) objAdded(cm CfgMap, co *CfgObj) {
+	j.record(JournalEntry{Op: J_ADD, obj: co})
+}
+
+func (j *Journal) objRemoved(cm CfgMap, co *CfgObj) {
+	j.record(JournalEntry{Op: J_REMOVE, obj: co})
+}
+
+func (j *Journal) begin(desc string) {
+	j.batchDepth++
+	if j.batchDepth == 1 {
+		j.batchCnt++
+		j.batch = j.batchCnt
+		j.batchDesc = desc
+	}
+}
+
+func (j *Journal) end() {
+	j.batchDepth--
+	if j.batchDepth == 0 {
+		j.batch = 0
+		j.batchDesc = ""
+	}
+}
+
+// Begin starts a batch, so that all changes until End are undone and redone as one.
+// Batches can be nested, in which case the outermost one decides.
+func (j *Journal) Begin(desc string) {
+	j.begin(desc)
+}
+
+// End ends the batch started by Begin
+func (j *Journal) End() {
+	j.end()
+}
+
+// target returns the object to change for e: the one in the map now, which may be a copy of e.obj if it was
+// frozen by a snapshot (see snapshot.go)
+func (j *Journal) target(e JournalEntry) *CfgObj {
+	if _, found := j.cm[e.UUID]; found {
+		return j.cm.writable(e.UUID)
+	}
+	return e.obj
+}
+
+// revert undoes a single entry
+func (j *Journal) revert(e JournalEntry) {
+	switch e.Op {
+	case J_SET:
+		if e.Old == "" {
+			j.target(e).Del(e.Key)
+		} else {
+			j.target(e).Set(e.Key, e.Old)
+		}
+	case J_DEL:
+		j.target(e).Set(e.Key, e.Old)
+	case J_ADD:
+		j.cm.DelByUUID(e.UUID)
+	case J_REMOVE:
+		j.cm.SetByUUID(e.UUID, e.obj)
+	}
+}
+
+// reapply redoes a single entry
+func (j *Journal) reapply(e JournalEntry) {
+	switch e.Op {
+	case J_SET:
+		j.target(e).Set(e.Key, e.New)
+	case J_DEL:
+		j.target(e).Del(e.Key)
+	case J_ADD:
+		j.cm.SetByUUID(e.UUID, e.obj)
+	case J_REMOVE:
+		j.cm.DelByUUID(e.UUID)
+	}
+}
+
+// replayed adds e to the history as undone or redone now
+func (j *Journal) replayed(e JournalEntry, replay string) {
+	e.Time = time.Now()
+	e.Replay = replay
+	j.history = append(j.history, e)
+}
+
+// Undo undoes the last change, or batch of changes
+func (j *Journal) Undo() error {
+	if len(j.done) == 0 || j.cm == nil {
+		return ErrNothingToUndo
+	}
+	group := j.done[len(j.done)-1]
+	j.done = j.done[:len(j.done)-1]
+	j.replaying = true
+	for i := len(group) - 1; i >= 0; i-- {
+		j.revert(group[i])
+		j.replayed(group[i], J_REPLAY_UNDO)
+	}
+	j.replaying = false
+	j.undone = append(j.undone, group)
+	return nil
+}
+
+// Redo redoes the last undone change, or batch of changes
+func (j *Journal) Redo() error {
+	if len(j.undone) == 0 || j.cm == nil {
+		return ErrNothingToRedo
+	}
+	group := j.undone[len(j.undone)-1]
+	j.undone = j.undone[:len(j.undone)-1]
+	j.replaying = true
+	for i := range group {
+		j.reapply(group[i])
+		j.replayed(group[i], J_REPLAY_REDO)
+	}
+	j.replaying = false
+	j.done = append(j.done, group)
+	return nil
+}
+
+// Checkpoint marks the current state with the given label, for RollbackTo. An existing label is moved.
+func (j *Journal) Checkpoint(label string) {
+	j.checkpoints[label] = len(j.done)
+}
+
+// RollbackTo undoes all changes made after the checkpoint with the given label. The changes can be redone.
+func (j *Journal) RollbackTo(label string) error {
+	pos, found := j.checkpoints[label]
+	if !found {
+		return fmt.Errorf("No such checkpoint: %q %s", label, dbgStr(true))
+	}
+	if pos > len(j.done) {
+		return fmt.Errorf("Checkpoint %q is after the current state, use Redo %s", label, dbgStr(true))
+	}
+	for len(j.done) > pos {
+		err := j.Undo()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of steps that can be undone
+func (j *Journal) Len() int {
+	return len(j.done)
+}
+
+// Entries returns all changes that can be undone, oldest first
+func (j *Journal) Entries() []JournalEntry {
+	return flatten(j.done)
+}
+
+// Undone returns all changes that can be redone, in the order they were made
+func (j *Journal) Undone() []JournalEntry {
+	var entries []JournalEntry
+	for i := len(j.undone) - 1; i >= 0; i-- {
+		entries = append(entries, j.undone[i]...)
+	}
+	return entries
+}
+
+// History returns every change recorded, and every undo and redo of them, in the order it happened.
+// Changes that were undone and then made impossible to redo by a new change are still there.
+func (j *Journal) History() []JournalEntry {
+	return j.history
+}
+
+func flatten(groups [][]JournalEntry) []JournalEntry {
+	var entries []JournalEntry
+	for _, group := range groups {
+		entries = append(entries, group...)
+	}
+	return entries
+}
+
+func (e JournalEntry) MarshalJSON() ([]byte, error) {
+	tmp := struct {
+		Op     string            `json:"op"`
+		Time   time.Time         `json:"time"`
+		Batch  int               `json:"batch,omitempty"`
+		Desc   string            `json:"desc,omitempty"`
+		UUID   UUID              `json:"uuid"`
+		Type   string            `json:"type"`
+		Key    string            `json:"key,omitempty"`
+		Old    string            `json:"old,omitempty"`
+		New    string            `json:"new,omitempty"`
+		Props  map[string]string `json:"props,omitempty"`
+		Replay string            `json:"replay,omitempty"`
+	}{
+		Op:     e.Op.String(),
+		Time:   e.Time,
+		Batch:  e.Batch,
+		Desc:   e.Desc,
+		UUID:   e.UUID,
+		Type:   e.Type.String(),
+		Key:    e.Key,
+		Old:    e.Old,
+		New:    e.New,
+		Props:  e.Props,
+		Replay: e.Replay,
+	}
+	return json.Marshal(tmp)
+}
+
+// MarshalJSON exports all changes that can be undone and redone, the full history, and the checkpoints
+func (j *Journal) MarshalJSON() ([]byte, error) {
+	nonNil := func(es []JournalEntry) []JournalEntry {
+		if es == nil {
+			return []JournalEntry{}
+		}
+		return es
+	}
+	return json.Marshal(struct {
+		Entries     []JournalEntry `json:"entries"`
+		Undone      []JournalEntry `json:"undone"`
+		History     []JournalEntry `json:"history"`
+		Checkpoints map[string]int `json:"checkpoints"`
+	}{nonNil(j.Entries()), nonNil(j.Undone()), nonNil(j.history), j.checkpoints})
+}
@@ -0,0 +1,198 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"encoding/json"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalUndoRedo(t *testing.T) {
+	nc := loadDiffCfg(t, diffcfgstr_a)
+	orig := nc.Config.Copy()
+	j := nc.EnableJournal()
+	defer nc.DisableJournal()
+
+	host := nc.Config[nc.Config.FilterType(T_HOST)[0]]
+	host.Set("address", "10.0.0.99")
+	host.Del("alias")
+	host.Set("notes", "journaled")
+	if j.Len() != 3 {
+		t.Fatalf("Expected 3 entries, got %d", j.Len())
+	}
+
+	j.Checkpoint("before batch")
+	nc.matches = nc.Config.FilterType(T_SERVICE, T_HOSTDEPENDENCY)
+	nc.SetKeys([]string{"notes"}, []string{"batch"})
+	deleted := nc.DeleteMatches()
+	if len(deleted) != 2 || j.Len() != 5 {
+		t.Fatalf("Expected 2 deleted and 5 steps, got %d and %d", len(deleted), j.Len())
+	}
+
+	cmd := NewCfgObjWithUUID(T_COMMAND)
+	cmd.Add("command_name", "check_new")
+	cmd.Add("command_line", "/bin/true")
+	nc.Config.AddByUUID(cmd.UUID, cmd)
+	cmd.Set("command_line", "/bin/false") // recorded, as the object is in the map now
+
+	err := j.RollbackTo("before batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nc.Config.Len() != orig.Len() {
+		t.Errorf("Expected %d objects after rollback, got %d", orig.Len(), nc.Config.Len())
+	}
+	for _, u := range nc.Config.FilterType(T_SERVICE) {
+		if _, found := nc.Config[u].Get("notes"); found {
+			t.Error("Batch SetKeys not rolled back")
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := j.Undo(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Undo(); err != ErrNothingToUndo {
+		t.Errorf("Expected %q, got %v", ErrNothingToUndo, err)
+	}
+	if d := DiffMaps(orig, nc.Config); !d.Empty() {
+		t.Errorf("Expected original config after undoing everything, got %d differences", d.Len())
+	}
+
+	// redo the single changes and the batch
+	for i := 0; i < 5; i++ {
+		if err := j.Redo(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, _ := host.Get("notes"); v != "journaled" || nc.Config.Len() != orig.Len()-2 {
+		t.Errorf("Redo failed: notes %q, %d objects", v, nc.Config.Len())
+	}
+
+	// a new change clears the redo stack
+	host.Set("notes", "new")
+	if err := j.Redo(); err != ErrNothingToRedo {
+		t.Errorf("Expected %q, got %v", ErrNothingToRedo, err)
+	}
+}
+
+func TestJournalExport(t *testing.T) {
+	nc := loadDiffCfg(t, diffcfgstr_a)
+	j := nc.EnableJournal()
+	defer nc.DisableJournal()
+
+	p, err := ParsePatch([]byte(`{"ops": [{"op": "set", "type": "host", "props": {"notes": "x"}}, {"op": "delete", "type": "command"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = nc.ApplyPatch(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Len() != 1 {
+		t.Errorf("Expected patch as one step, got %d", j.Len())
+	}
+
+	b, err := json.Marshal(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tmp struct {
+		Entries []map[string]interface{} `json:"entries"`
+	}
+	err = json.Unmarshal(b, &tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tmp.Entries) != 2 || tmp.Entries[0]["op"] != "set" || tmp.Entries[1]["op"] != "remove" || tmp.Entries[1]["desc"] != "Patch" {
+		t.Errorf("Unexpected export: %s", b)
+	}
+
+	// changes after disabling are not recorded
+	nc.DisableJournal()
+	host := nc.Config[nc.Config.FilterType(T_HOST)[0]]
+	host.Set("notes", "y")
+	if j.Len() != 1 || !strings.Contains(string(b), `"key":"notes"`) {
+		t.Errorf("Unexpected journal after disable: %d", j.Len())
+	}
+}
+
+func TestJournalHistory(t *testing.T) {
+	nc := loadDiffCfg(t, diffcfgstr_a)
+	j := nc.EnableJournal()
+	defer nc.DisableJournal()
+
+	cmd := NewCfgObjWithUUID(T_COMMAND)
+	cmd.Add("command_name", "check_new")
+	nc.Config.AddByUUID(cmd.UUID, cmd)
+	cmd.Set("command_line", "/bin/true")
+	if err := j.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	nc.GetCommand("check_new").Set("command_line", "/bin/false") // drops the undone change from redo
+
+	entries := j.History()
+	exp := []string{"add", "set", "undo set", "set"}
+	got := make([]string, len(entries))
+	for i, e := range entries {
+		got[i] = strings.TrimSpace(e.Replay + " " + e.Op.String())
+	}
+	if !sameStrings(got, exp) {
+		t.Errorf("Expected history %v, got %v", exp, got)
+	}
+	if len(entries[0].Props) != 1 || entries[0].Props["command_name"] != "check_new" {
+		t.Errorf("Expected the props when added, got %v", entries[0].Props)
+	}
+
+	b, err := json.Marshal(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tmp struct {
+		Entries []map[string]interface{} `json:"entries"`
+		Undone  []map[string]interface{} `json:"undone"`
+		History []map[string]interface{} `json:"history"`
+	}
+	if err := json.Unmarshal(b, &tmp); err != nil {
+		t.Fatal(err)
+	}
+	if len(tmp.Entries) != 2 || len(tmp.Undone) != 0 || len(tmp.History) != 4 || tmp.History[2]["replay"] != "undo" {
+		t.Errorf("Unexpected export: %s", b)
+	}
+}
+
+func TestJournalReleased(t *testing.T) {
+	nc := loadDiffCfg(t, diffcfgstr_a)
+	cm := nc.Config
+	nc.EnableJournal()
+	nc.EnableIndex()
+	if observersOf(cm) == nil {
+		t.Fatal("Expected observers")
+	}
+	nc = nil
+	for i := 0; i < 50 && observersOf(cm) != nil; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if observersOf(cm) != nil {
+		t.Error("Observers should be detached when the config is gone")
+	}
+}
@@ -0,0 +1,126 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+Streaming JSON Lines (NDJSON) support, with one CfgObj per line.
+CfgMap.MarshalJSON needs the whole config in memory, and produces one huge document.
+This lets us go straight from Reader.ReadChan to the output, and lets tools like jq work on each object as it comes.
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+type JSONLinesEncoder struct {
+	w *bufio.Writer
+}
+
+type JSONLinesDecoder struct {
+	dec *json.Decoder
+}
+
+func NewJSONLinesEncoder(w io.Writer) *JSONLinesEncoder {
+	return &JSONLinesEncoder{
+		w: bufio.NewWriter(w),
+	}
+}
+
+// Encode writes the given object as a single line of JSON
+func (e *JSONLinesEncoder) Encode(co *CfgObj) error {
+	b, err := co.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+	if err != nil {
+		return err
+	}
+	return e.w.WriteByte('\n')
+}
+
+// EncodeChan encodes objects as they come in on the channel, until it is closed, and returns the number of objects
+// written. The channel is always drained, even after an error, so that the sender is not left hanging.
+func (e *JSONLinesEncoder) EncodeChan(in <-chan *CfgObj) (int, error) {
+	var cnt int
+	var err error
+	for co := range in {
+		if err != nil {
+			continue
+		}
+		err = e.Encode(co)
+		if err == nil {
+			cnt++
+		}
+	}
+	if err != nil {
+		return cnt, err
+	}
+	return cnt, e.Flush()
+}
+
+// EncodeMap encodes all objects in the given map, in the order given by CfgMap.Keys
+func (e *JSONLinesEncoder) EncodeMap(cm CfgMap) error {
+	for _, k := range cm.Keys() {
+		err := e.Encode(cm[k])
+		if err != nil {
+			return err
+		}
+	}
+	return e.Flush()
+}
+
+// Flush writes any buffered data to the underlying io.Writer
+func (e *JSONLinesEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+func NewJSONLinesDecoder(r io.Reader) *JSONLinesDecoder {
+	return &JSONLinesDecoder{
+		dec: json.NewDecoder(r),
+	}
+}
+
+// Decode returns the next object in the stream. Returns err = io.EOF when done
+func (d *JSONLinesDecoder) Decode() (*CfgObj, error) {
+	co := &CfgObj{}
+	err := d.dec.Decode(co)
+	if err != nil {
+		return nil, err
+	}
+	return co, nil
+}
+
+// DecodeMap reads the rest of the stream into a CfgMap, registering the objects in the order they come,
+// just like Reader does
+func (d *JSONLinesDecoder) DecodeMap() (CfgMap, error) {
+	cm := make(CfgMap)
+	for {
+		co, err := d.Decode()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return cm, err
+		}
+		cm[co.UUID] = co
+		addOrder(co.UUID)
+	}
+	return cm, nil
+}
@@ -0,0 +1,92 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONLinesRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewJSONLinesEncoder(&buf)
+	rdr := NewReader(strings.NewReader(cfgobjstr))
+	n, err := enc.EncodeChan(rdr.ReadChan(true, "/dev/null"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("Expected 3 objects encoded, got %d", n)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != n {
+		t.Fatalf("Expected %d lines, got %d:\n%s", n, len(lines), buf.String())
+	}
+	uuids := make(UUIDs, len(lines))
+	for i, l := range lines {
+		var tmp map[string]interface{}
+		if err := json.Unmarshal([]byte(l), &tmp); err != nil {
+			t.Errorf("Line #%d is not a JSON object: %s", i, err)
+			continue
+		}
+		uuids[i], _ = UUIDFromString(tmp["uuid"].(string))
+	}
+	if !strings.Contains(lines[1], `"type":"command"`) {
+		t.Errorf("Expected command as second object, got: %s", lines[1])
+	}
+
+	cm, err := NewJSONLinesDecoder(bytes.NewReader(buf.Bytes())).DecodeMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := cm.Keys()
+	if !reflect.DeepEqual(keys, uuids) {
+		t.Errorf("Order not kept. Expected %v, got %v", uuids, keys)
+	}
+
+	// and once more, from the map this time
+	var buf2 bytes.Buffer
+	err = NewJSONLinesEncoder(&buf2).EncodeMap(cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != buf2.String() {
+		t.Errorf("Output differs:\n%s\n%s", buf.String(), buf2.String())
+	}
+}
+
+func TestJSONLinesDecodeError(t *testing.T) {
+	in := `{"uuid":"0e03153b-7182-11e7-ba59-0800279d8583","fileid":"","type":"host","props":{"host_name":"h1"}}
+{"uuid":"0e03130a-7182-11e7-ba59-0800279d8583","fileid":"","type":"nosuchtype","props":{}}
+`
+	dec := NewJSONLinesDecoder(strings.NewReader(in))
+	co, err := dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := co.GetName(); name != "h1" {
+		t.Errorf("Expected host h1, got %q", name)
+	}
+	_, err = dec.Decode()
+	if err == nil {
+		t.Error("Expected error for invalid type")
+	}
+}
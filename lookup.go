@@ -0,0 +1,400 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+High level lookups, answering the questions usually asked about a config without writing searches.
+Host, hostgroup and service relations are found the way Nagios sees them: using template resolved values
+(see resolve.go), with hostgroups given in either the host's hostgroups or the group's members, nested groups in
+hostgroup_members, "*" for all hosts, and "!name" for exclusions. Templates (register 0) are never returned,
+except by GetTemplate.
+All results are the objects as they are in the config, not resolved copies. Use CfgMap.Resolve for the values.
+
+Working out the relations means resolving every host, hostgroup and service, so it's done once, and kept until
+the config changes. The cache is attached to the config as an observer (see hooks.go), and dropped on any
+change made through the API. Like the index, it also notices when objects are added or removed directly in the
+map, but not edits to Props behind its back.
+*/
+
+import (
+	"sync"
+)
+
+const (
+	LST_ALL     string = "*" // all hosts, in members, host_name and hostgroup_name
+	LST_EXCLUDE string = "!" // prefix for names excluded from a list
+)
+
+// hostRelations holds what's needed to find relations between hosts, hostgroups and services
+type hostRelations struct {
+	cm       CfgMap
+	tpls     templates
+	hosts    map[string]*CfgObj
+	members  map[string]map[string]bool // hostgroup => names of its hosts, with nested groups included
+	services map[string][]svcRef        // host => services applied to it, in original order
+	groupsOf map[string]CfgObjs         // host => hostgroups it's in, in original order
+	hostsIn  map[string]CfgObjs         // hostgroup => its hosts, in original order
+}
+
+// svcRef is a service applied to a host
+type svcRef struct {
+	co     *CfgObj
+	desc   string // resolved service_description
+	direct bool   // given in host_name, not through a hostgroup
+}
+
+// relCache keeps the hostRelations of a map until the map changes
+type relCache struct {
+	sync.Mutex
+	cm    CfgMap
+	hr    *hostRelations
+	count int // number of objects when hr was made, to notice changes made behind our back
+}
+
+func newRelCache(cm CfgMap) *relCache {
+	rc := &relCache{cm: cm}
+	attachObserver(cm, rc)
+	return rc
+}
+
+// Close detaches the cache from the map
+func (rc *relCache) Close() {
+	detachObserver(rc.cm, rc)
+	rc.reset()
+}
+
+func (rc *relCache) reset() {
+	rc.Lock()
+	rc.hr = nil
+	rc.Unlock()
+}
+
+func (rc *relCache) propChanged(co *CfgObj, key, oldVal, newVal string) {
+	rc.reset()
+}
+
+func (rc *relCache) objAdded(cm CfgMap, co *CfgObj) {
+	rc.reset()
+}
+
+func (rc *relCache) objRemoved(cm CfgMap, co *CfgObj) {
+	rc.reset()
+}
+
+// get returns the relations, working them out again if the map has changed. Safe for concurrent use, as long
+// as the map itself is not changed at the same time.
+func (rc *relCache) get() *hostRelations {
+	rc.Lock()
+	defer rc.Unlock()
+	if rc.hr == nil || rc.count != len(rc.cm) {
+		rc.hr = newHostRelations(rc.cm)
+		rc.count = len(rc.cm)
+	}
+	return rc.hr
+}
+
+// relCache returns the relation cache for the config, making a new one if there is none, or if the config
+// has been replaced since
+func (nc *NagiosCfg) relCache() *relCache {
+	if nc.rels != nil && mapID(nc.rels.cm) == mapID(nc.Config) {
+		return nc.rels
+	}
+	if nc.rels != nil {
+		nc.rels.Close()
+		nc.disown(nc.rels)
+	}
+	nc.rels = newRelCache(nc.Config)
+	nc.own(nc.rels)
+	return nc.rels
+}
+
+func (nc *NagiosCfg) hostRelations() *hostRelations {
+	return nc.relCache().get()
+}
+
+// isTemplate returns true for objects not registered, which are only used for inheritance
+func (co *CfgObj) isTemplate() bool {
+	reg, found := co.Get("register")
+	return found && reg == "0"
+}
+
+// resolvedList returns the template resolved value of key as a list, or nil if not set
+func (hr *hostRelations) resolvedList(co *CfgObj, key string) []string {
+	rp, found := hr.tpls.resolve(co, make(map[*CfgObj]bool))[key]
+	if !found {
+		return nil
+	}
+	return splitList(rp.Value)
+}
+
+func newHostRelations(cm CfgMap) *hostRelations {
+	hr := &hostRelations{
+		cm:       cm,
+		tpls:     cm.templateIndex(),
+		hosts:    make(map[string]*CfgObj),
+		members:  make(map[string]map[string]bool),
+		services: make(map[string][]svcRef),
+		groupsOf: make(map[string]CfgObjs),
+		hostsIn:  make(map[string]CfgObjs),
+	}
+	add := func(group, host string) {
+		if hr.members[group] == nil {
+			hr.members[group] = make(map[string]bool)
+		}
+		hr.members[group][host] = true
+	}
+
+	for _, u := range cm.FilterType(T_HOST) {
+		co := cm[u]
+		name, found := co.Get("host_name")
+		if !found || co.isTemplate() {
+			continue
+		}
+		if _, found := hr.hosts[name]; !found {
+			hr.hosts[name] = co
+		}
+	}
+	for name, co := range hr.hosts {
+		for _, g := range hr.resolvedList(co, "hostgroups") {
+			add(g, name)
+		}
+	}
+
+	// members and nested groups from the hostgroup definitions
+	excluded := make(map[string]map[string]bool)
+	nested := make(map[string][]string)
+	for _, u := range cm.FilterType(T_HOSTGROUP) {
+		co := cm[u]
+		group, found := co.Get("hostgroup_name")
+		if !found || co.isTemplate() {
+			continue
+		}
+		for _, m := range hr.resolvedList(co, "members") {
+			switch {
+			case m == LST_ALL:
+				for name := range hr.hosts {
+					add(group, name)
+				}
+			case len(m) > 1 && m[:1] == LST_EXCLUDE:
+				if excluded[group] == nil {
+					excluded[group] = make(map[string]bool)
+				}
+				excluded[group][m[1:]] = true
+			case m != "":
+				add(group, m)
+			}
+		}
+		nested[group] = append(nested[group], hr.resolvedList(co, "hostgroup_members")...)
+	}
+
+	// expand nested groups, guarding against loops
+	var expand func(group string, visited map[string]bool) map[string]bool
+	expand = func(group string, visited map[string]bool) map[string]bool {
+		res := make(map[string]bool)
+		if visited[group] {
+			return res
+		}
+		visited[group] = true
+		for h := range hr.members[group] {
+			res[h] = true
+		}
+		for _, sub := range nested[group] {
+			for h := range expand(sub, visited) {
+				res[h] = true
+			}
+		}
+		for h := range excluded[group] {
+			delete(res, h)
+		}
+		return res
+	}
+	expanded := make(map[string]map[string]bool, len(nested))
+	for group := range nested {
+		expanded[group] = expand(group, make(map[string]bool))
+	}
+	for group := range hr.members {
+		if _, found := expanded[group]; !found {
+			expanded[group] = hr.members[group] // only given by hosts, there's no definition for the group
+		}
+	}
+	hr.members = expanded
+	hr.mapGroups()
+	hr.mapServices()
+	return hr
+}
+
+// mapGroups fills in groupsOf and hostsIn from members
+func (hr *hostRelations) mapGroups() {
+	for _, u := range hr.cm.FilterType(T_HOSTGROUP) {
+		co := hr.cm[u]
+		group, found := co.Get("hostgroup_name")
+		if !found || co.isTemplate() {
+			continue
+		}
+		for host := range hr.members[group] {
+			hr.groupsOf[host] = append(hr.groupsOf[host], co)
+		}
+	}
+	groupNames := make(map[string][]string)
+	for group, hosts := range hr.members {
+		for host := range hosts {
+			groupNames[host] = append(groupNames[host], group)
+		}
+	}
+	for _, u := range hr.cm.FilterType(T_HOST) {
+		co := hr.cm[u]
+		name, _ := co.Get("host_name")
+		if hr.hosts[name] != co {
+			continue // templates, and all but the first host with the same name
+		}
+		for _, group := range groupNames[name] {
+			hr.hostsIn[group] = append(hr.hostsIn[group], co)
+		}
+	}
+}
+
+// mapServices fills in services, with the hosts each service is applied to
+func (hr *hostRelations) mapServices() {
+	for _, u := range hr.cm.FilterType(T_SERVICE) {
+		co := hr.cm[u]
+		if co.isTemplate() {
+			continue
+		}
+		rps := hr.tpls.resolve(co, make(map[*CfgObj]bool))
+		list := func(key string) []string {
+			if rp, found := rps[key]; found {
+				return splitList(rp.Value)
+			}
+			return nil
+		}
+		hosts, groups := list("host_name"), list("hostgroup_name")
+
+		// the hosts it may apply to, before exclusions
+		candidates := make(map[string]bool)
+		all := false
+		for _, h := range hosts {
+			all = all || h == LST_ALL
+			candidates[h] = true
+		}
+		for _, g := range groups {
+			all = all || g == LST_ALL
+			for h := range hr.members[g] {
+				candidates[h] = true
+			}
+		}
+		if all {
+			for h := range hr.hosts {
+				candidates[h] = true
+			}
+		}
+		for h := range candidates {
+			if applies, direct := hr.applies(hosts, groups, h); applies {
+				hr.services[h] = append(hr.services[h], svcRef{co: co, desc: rps["service_description"].Value, direct: direct})
+			}
+		}
+	}
+}
+
+// applies tells if a service with the given resolved host_name and hostgroup_name lists is applied to host,
+// and if so, if it's given directly in host_name
+func (hr *hostRelations) applies(hosts, groups []string, host string) (applies bool, direct bool) {
+	for _, h := range hosts {
+		switch {
+		case h == LST_EXCLUDE+host:
+			return false, false
+		case h == host:
+			direct = true
+		case h == LST_ALL:
+			applies = true
+		}
+	}
+	for _, g := range groups {
+		switch {
+		case len(g) > 1 && g[:1] == LST_EXCLUDE:
+			if hr.members[g[1:]][host] {
+				return false, false
+			}
+		case g == LST_ALL || hr.members[g][host]:
+			applies = true
+		}
+	}
+	return applies || direct, direct
+}
+
+// getRegistered returns the first object of the given type and identity that is not a template
+func (nc *NagiosCfg) getRegistered(ct CfgType, id string) *CfgObj {
+	for _, u := range nc.Config.FindByIdentity(ct, id) {
+		if co := nc.Config[u]; !co.isTemplate() {
+			return co
+		}
+	}
+	return nil
+}
+
+// GetHost returns the host with the given host_name, or nil if not found
+func (nc *NagiosCfg) GetHost(name string) *CfgObj {
+	return nc.getRegistered(T_HOST, name)
+}
+
+// GetCommand returns the command with the given command_name, or nil if not found
+func (nc *NagiosCfg) GetCommand(name string) *CfgObj {
+	return nc.getRegistered(T_COMMAND, name)
+}
+
+// GetTemplate returns the object of the given type with the given name, which is what "use" refers to,
+// or nil if not found
+func (nc *NagiosCfg) GetTemplate(ct CfgType, name string) *CfgObj {
+	return nc.Config.templateIndex()[ct][name]
+}
+
+// ServicesForHost returns all services applied to the host, given by host_name or through hostgroups,
+// in original order
+func (nc *NagiosCfg) ServicesForHost(name string) CfgObjs {
+	var res CfgObjs
+	for _, sr := range nc.hostRelations().services[name] {
+		res = append(res, sr.co)
+	}
+	return res
+}
+
+// GetService returns the service with the given description on the host, or nil if not found.
+// As in Nagios, a service given by host_name wins over one applied through a hostgroup.
+func (nc *NagiosCfg) GetService(host, desc string) *CfgObj {
+	var viaGroup *CfgObj
+	for _, sr := range nc.hostRelations().services[host] {
+		if sr.desc != desc {
+			continue
+		}
+		if sr.direct {
+			return sr.co
+		}
+		if viaGroup == nil {
+			viaGroup = sr.co
+		}
+	}
+	return viaGroup
+}
+
+// HostgroupsOf returns the hostgroups the host is a member of, in original order
+func (nc *NagiosCfg) HostgroupsOf(host string) CfgObjs {
+	return append(CfgObjs(nil), nc.hostRelations().groupsOf[host]...)
+}
+
+// MembersOf returns the hosts in the hostgroup, including those in nested groups, in original order
+func (nc *NagiosCfg) MembersOf(hostgroup string) CfgObjs {
+	return append(CfgObjs(nil), nc.hostRelations().hostsIn[hostgroup]...)
+}
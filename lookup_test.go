@@ -0,0 +1,163 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"testing"
+)
+
+var lookupcfgstr string = `define host{
+	name web-host
+	hostgroups webservers
+	register 0
+}
+define host{
+	host_name web01
+	use web-host
+}
+define host{
+	host_name web02
+	use web-host
+	hostgroups +linux
+}
+define host{
+	host_name db01
+}
+define hostgroup{
+	hostgroup_name webservers
+}
+define hostgroup{
+	hostgroup_name linux
+	members db01
+}
+define hostgroup{
+	hostgroup_name all-linux
+	hostgroup_members linux,webservers
+	members !web01
+}
+define service{
+	name generic-service
+	service_description SSH
+	register 0
+}
+define service{
+	hostgroup_name all-linux
+	use generic-service
+}
+define service{
+	hostgroup_name webservers
+	service_description HTTP
+	check_command check_http
+}
+define service{
+	host_name web01
+	service_description HTTP
+	check_command check_http!-p 8080
+}
+define service{
+	host_name *,!db01
+	service_description PING
+}
+define command{
+	command_name check_http
+	command_line /bin/true
+}
+`
+
+func propValues(cos CfgObjs, key string) []string {
+	res := make([]string, len(cos))
+	for i := range cos {
+		res[i] = cos[i].Props[key]
+	}
+	return res
+}
+
+func TestLookup(t *testing.T) {
+	nc := loadDiffCfg(t, lookupcfgstr)
+
+	if co := nc.GetHost("web01"); co == nil || co.Props["use"] != "web-host" {
+		t.Errorf("GetHost failed: %v", co)
+	}
+	if nc.GetHost("web-host") != nil || nc.GetHost("nonexistent") != nil {
+		t.Error("GetHost should not find templates or unknown hosts")
+	}
+	if nc.GetCommand("check_http") == nil {
+		t.Error("GetCommand failed")
+	}
+	if co := nc.GetTemplate(T_SERVICE, "generic-service"); co == nil || co.Type != T_SERVICE {
+		t.Error("GetTemplate failed")
+	}
+
+	tests := []struct {
+		got, exp []string
+	}{
+		{propValues(nc.MembersOf("webservers"), "host_name"), []string{"web01", "web02"}},
+		{propValues(nc.MembersOf("all-linux"), "host_name"), []string{"web02", "db01"}},
+		{propValues(nc.HostgroupsOf("web02"), "hostgroup_name"), []string{"webservers", "linux", "all-linux"}},
+		{propValues(nc.HostgroupsOf("web01"), "hostgroup_name"), []string{"webservers"}},
+		{propValues(nc.ServicesForHost("web01"), "service_description"), []string{"HTTP", "HTTP", "PING"}},
+		{propValues(nc.ServicesForHost("db01"), "hostgroup_name"), []string{"all-linux"}},
+		{propValues(nc.ServicesForHost("web02"), "service_description"), []string{"", "HTTP", "PING"}},
+	}
+	for i, test := range tests {
+		if !sameStrings(test.got, test.exp) {
+			t.Errorf("#%d: expected %v, got %v", i, test.exp, test.got)
+		}
+	}
+
+	if co := nc.GetService("web01", "HTTP"); co == nil || co.Props["check_command"] != "check_http!-p 8080" {
+		t.Errorf("GetService should prefer the service given by host_name, got %v", co)
+	}
+	if co := nc.GetService("web02", "HTTP"); co == nil || co.Props["hostgroup_name"] != "webservers" {
+		t.Errorf("GetService via hostgroup failed, got %v", co)
+	}
+	if co := nc.GetService("db01", "SSH"); co == nil {
+		t.Error("GetService should see service_description inherited from template")
+	}
+	if co := nc.GetService("db01", "PING"); co != nil {
+		t.Error("GetService should honor exclusions")
+	}
+}
+
+func TestLookupCache(t *testing.T) {
+	nc := loadDiffCfg(t, lookupcfgstr)
+	hr := nc.hostRelations()
+	if nc.GetService("web02", "HTTP") == nil || nc.hostRelations() != hr {
+		t.Fatal("Relations should be kept between lookups")
+	}
+
+	nc.GetHost("web02").Set("hostgroups", "linux") // no longer in webservers
+	if nc.hostRelations() == hr {
+		t.Error("Relations should be dropped on changes")
+	}
+	if nc.GetService("web02", "HTTP") != nil {
+		t.Error("Expected no HTTP on web02 after leaving webservers")
+	}
+
+	co := NewCfgObjWithUUID(T_HOST)
+	co.Add("host_name", "db02")
+	co.Add("hostgroups", "linux")
+	nc.Config[co.UUID] = co // behind its back
+	if got := propValues(nc.MembersOf("linux"), "host_name"); !sameStrings(got, []string{"web02", "db01", "db02"}) {
+		t.Errorf("MembersOf after direct add: got %v", got)
+	}
+
+	nc.Config = loadDiffCfg(t, querycfgstr).Config
+	if nc.GetHost("web01") == nil || len(nc.ServicesForHost("web01")) != 1 {
+		t.Error("Relations should follow the config when it's replaced")
+	}
+}
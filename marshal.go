@@ -0,0 +1,387 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+Mapping between CfgObj and tagged Go structs, so that tools don't have to juggle Props by hand.
+Fields are mapped by struct tags, like this:
+
+	type Service struct {
+		Host          string            `nagios:"host_name"`
+		Description   string            `nagios:"service_description"`
+		Use           []string          `nagios:"use"`
+		CheckCommand  []string          `nagios:"check_command"`
+		CheckInterval *int              `nagios:"check_interval"`
+		ActiveChecks  bool              `nagios:"active_checks_enabled,omitempty"`
+		Other         map[string]string `nagios:",remain"`
+	}
+
+Supported field types are string, bool, all int, uint and float types, []string and pointers to those.
+Bools are "1" or "0" in Nagios format. []string is split on "!" for check_command and event_handler, or for
+any field tagged with the "args" option, and on "," otherwise. A map[string]string field tagged with "remain"
+gets all properties not claimed by any other field, like custom variables and keys we don't know about.
+Fields without a tag, or tagged with "-", are ignored.
+
+When marshaling, nil pointers, empty strings and empty slices are always left out, as Nagios has no way to
+express them. Other zero values are only left out when tagged with "omitempty".
+*/
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	NAGIOS_TAG       string = "nagios"
+	NAGIOS_OPT_OMIT  string = "omitempty"
+	NAGIOS_OPT_ARGS  string = "args"
+	NAGIOS_OPT_CATCH string = "remain"
+)
+
+// nagiosField describes how a struct field maps to a property
+type nagiosField struct {
+	index     int
+	key       string
+	sep       string
+	omitEmpty bool
+}
+
+// nagiosFields describes all mapped fields of a struct type
+type nagiosFields struct {
+	fields []nagiosField
+	remain int // index of the catch-all field, or -1 if none
+}
+
+// isCmdKey tells if the value for the given key is a command followed by "!" separated arguments
+func isCmdKey(key string) bool {
+	return key == "check_command" || key == "event_handler"
+}
+
+func typeFields(t reflect.Type) (*nagiosFields, error) {
+	nf := &nagiosFields{remain: -1}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup(NAGIOS_TAG)
+		if !ok || tag == "-" || sf.PkgPath != "" { // untagged, skipped or unexported
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		f := nagiosField{
+			index: i,
+			key:   opts[0],
+			sep:   SEP_LST,
+		}
+		if isCmdKey(f.key) {
+			f.sep = SEP_CMD
+		}
+		catchAll := false
+		for _, o := range opts[1:] {
+			switch o {
+			case NAGIOS_OPT_OMIT:
+				f.omitEmpty = true
+			case NAGIOS_OPT_ARGS:
+				f.sep = SEP_CMD
+			case NAGIOS_OPT_CATCH:
+				catchAll = true
+			default:
+				return nil, fmt.Errorf("Unknown option %q in tag for field %s %s", o, sf.Name, dbgStr(true))
+			}
+		}
+		if catchAll {
+			if sf.Type != reflect.TypeOf(map[string]string{}) {
+				return nil, fmt.Errorf("Field %s must be map[string]string to use %q %s", sf.Name, NAGIOS_OPT_CATCH, dbgStr(true))
+			}
+			if nf.remain >= 0 {
+				return nil, fmt.Errorf("More than one %q field in %s %s", NAGIOS_OPT_CATCH, t, dbgStr(true))
+			}
+			nf.remain = i
+			continue
+		}
+		if f.key == "" {
+			return nil, fmt.Errorf("Missing property name in tag for field %s %s", sf.Name, dbgStr(true))
+		}
+		nf.fields = append(nf.fields, f)
+	}
+	return nf, nil
+}
+
+func parseBool(s string) (bool, error) {
+	switch s {
+	case "1":
+		return true, nil
+	case "0":
+		return false, nil
+	}
+	return strconv.ParseBool(s)
+}
+
+// setValue converts val and stores it in fv
+func setValue(fv reflect.Value, val, sep string) error {
+	switch fv.Kind() {
+	case reflect.Ptr:
+		pv := reflect.New(fv.Type().Elem())
+		err := setValue(pv.Elem(), val, sep)
+		if err != nil {
+			return err
+		}
+		fv.Set(pv)
+	case reflect.String:
+		fv.SetString(val)
+	case reflect.Bool:
+		b, err := parseBool(strings.TrimSpace(val))
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(strings.TrimSpace(val), 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(strings.TrimSpace(val), 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("Unsupported type %s", fv.Type())
+		}
+		lst := strings.Split(val, sep)
+		if sep == SEP_LST {
+			for i := range lst {
+				lst[i] = strings.TrimSpace(lst[i])
+			}
+		}
+		fv.Set(reflect.ValueOf(lst).Convert(fv.Type()))
+	default:
+		return fmt.Errorf("Unsupported type %s", fv.Type())
+	}
+	return nil
+}
+
+// formatValue returns fv in Nagios format. ok is false if there is nothing to write.
+func formatValue(fv reflect.Value, sep string) (val string, ok bool, err error) {
+	switch fv.Kind() {
+	case reflect.Ptr:
+		if fv.IsNil() {
+			return "", false, nil
+		}
+		return formatValue(fv.Elem(), sep)
+	case reflect.String:
+		return fv.String(), fv.Len() > 0, nil
+	case reflect.Bool:
+		if fv.Bool() {
+			return "1", true, nil
+		}
+		return "0", true, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fv.Int(), 10), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(fv.Uint(), 10), true, nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(fv.Float(), 'f', -1, fv.Type().Bits()), true, nil
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			break
+		}
+		if fv.Len() == 0 {
+			return "", false, nil
+		}
+		lst := make([]string, fv.Len())
+		for i := range lst {
+			lst[i] = fv.Index(i).String()
+		}
+		return strings.Join(lst, sep), true, nil
+	}
+	return "", false, fmt.Errorf("Unsupported type %s", fv.Type())
+}
+
+// isEmptyValue is used for omitempty, same rules as in encoding/json
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// structValue returns the struct v points to
+func structValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("Expected non-nil pointer to struct, got %T %s", v, dbgStr(true))
+	}
+	return rv.Elem(), nil
+}
+
+func unmarshalStruct(co *CfgObj, sv reflect.Value) error {
+	nf, err := typeFields(sv.Type())
+	if err != nil {
+		return err
+	}
+	claimed := make(map[string]bool, len(nf.fields))
+	for _, f := range nf.fields {
+		claimed[f.key] = true
+		val, found := co.Get(f.key)
+		if !found {
+			continue
+		}
+		err := setValue(sv.Field(f.index), val, f.sep)
+		if err != nil {
+			return fmt.Errorf("Unable to set %s from %q=%q: %s %s", sv.Type().Field(f.index).Name, f.key, val, err, dbgStr(true))
+		}
+	}
+	if nf.remain >= 0 {
+		rest := make(map[string]string)
+		for k, v := range co.Props {
+			if !claimed[k] {
+				rest[k] = v
+			}
+		}
+		sv.Field(nf.remain).Set(reflect.ValueOf(rest))
+	}
+	return nil
+}
+
+// Unmarshal stores the properties of co in the struct pointed to by v, according to its "nagios" field tags.
+// Fields for properties not in co are left untouched.
+func Unmarshal(co *CfgObj, v interface{}) error {
+	sv, err := structValue(v)
+	if err != nil {
+		return err
+	}
+	return unmarshalStruct(co, sv)
+}
+
+// Marshal returns a new CfgObj of the given type, with UUID set and properties taken from the struct, or pointer
+// to struct, v. Properties from a "remain" field are added after the tagged fields, and never overwrite them.
+func Marshal(v interface{}, ct CfgType) (*CfgObj, error) {
+	if !ct.Valid() {
+		return nil, fmt.Errorf("Invalid object type: %d %s", ct, dbgStr(true))
+	}
+	sv := reflect.ValueOf(v)
+	if sv.Kind() == reflect.Ptr && !sv.IsNil() {
+		sv = sv.Elem()
+	}
+	if sv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("Expected struct or pointer to struct, got %T %s", v, dbgStr(true))
+	}
+	nf, err := typeFields(sv.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	co := NewCfgObjWithUUID(ct)
+	set := func(key, val string) error {
+		if !IsValidProperty(key) {
+			return fmt.Errorf("Invalid property: %q %s", key, dbgStr(true))
+		}
+		co.Set(key, val)
+		return nil
+	}
+	for _, f := range nf.fields {
+		fv := sv.Field(f.index)
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		val, ok, err := formatValue(fv, f.sep)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to format %s: %s %s", sv.Type().Field(f.index).Name, err, dbgStr(true))
+		}
+		if !ok {
+			continue
+		}
+		err = set(f.key, val)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if nf.remain >= 0 {
+		for k, val := range sv.Field(nf.remain).Interface().(map[string]string) {
+			if _, exists := co.Props[k]; exists {
+				continue
+			}
+			err = set(k, val)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return co, nil
+}
+
+// Unmarshal stores each object in a new element appended to the slice pointed to by v.
+// The slice may be of structs or of pointers to structs.
+func (cos CfgObjs) Unmarshal(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("Expected non-nil pointer to slice, got %T %s", v, dbgStr(true))
+	}
+	slv := rv.Elem()
+	et := slv.Type().Elem()
+	isPtr := et.Kind() == reflect.Ptr
+	if isPtr {
+		et = et.Elem()
+	}
+	if et.Kind() != reflect.Struct {
+		return fmt.Errorf("Expected slice of structs, got %T %s", v, dbgStr(true))
+	}
+	for i := range cos {
+		pv := reflect.New(et)
+		err := unmarshalStruct(cos[i], pv.Elem())
+		if err != nil {
+			return err
+		}
+		if isPtr {
+			slv.Set(reflect.Append(slv, pv))
+		} else {
+			slv.Set(reflect.Append(slv, pv.Elem()))
+		}
+	}
+	return nil
+}
+
+// UnmarshalType is like CfgObjs.Unmarshal, for all objects of the given type in cm, in original order
+func (cm CfgMap) UnmarshalType(ct CfgType, v interface{}) error {
+	ids := cm.FilterType(ct)
+	cos := make(CfgObjs, 0, len(ids))
+	for _, id := range ids {
+		cos = append(cos, cm[id])
+	}
+	return cos.Unmarshal(v)
+}
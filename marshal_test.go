@@ -0,0 +1,154 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testService struct {
+	Host          string            `nagios:"host_name"`
+	Description   string            `nagios:"service_description"`
+	Use           []string          `nagios:"use"`
+	CheckCommand  []string          `nagios:"check_command"`
+	CheckInterval *int              `nagios:"check_interval"`
+	MaxAttempts   int               `nagios:"max_check_attempts,omitempty"`
+	ActiveChecks  bool              `nagios:"active_checks_enabled"`
+	Other         map[string]string `nagios:",remain"`
+	Ignored       string
+}
+
+func TestUnmarshal(t *testing.T) {
+	co := NewCfgObj(T_SERVICE)
+	co.Add("host_name", "web01")
+	co.Add("service_description", "HTTP")
+	co.Add("use", "generic-service, graphed-service")
+	co.Add("check_command", "check_http!-p 8080!-u /health")
+	co.Add("check_interval", "5")
+	co.Add("active_checks_enabled", "1")
+	co.Add("_PORT", "8080")
+	co.Add("notes", "some notes")
+
+	var s testService
+	err := Unmarshal(co, &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Host != "web01" || s.Description != "HTTP" || !s.ActiveChecks {
+		t.Errorf("Wrong values: %+v", s)
+	}
+	if !reflect.DeepEqual(s.Use, []string{"generic-service", "graphed-service"}) {
+		t.Errorf("Wrong use: %q", s.Use)
+	}
+	if !reflect.DeepEqual(s.CheckCommand, []string{"check_http", "-p 8080", "-u /health"}) {
+		t.Errorf("Wrong check_command: %q", s.CheckCommand)
+	}
+	if s.CheckInterval == nil || *s.CheckInterval != 5 {
+		t.Errorf("Wrong check_interval: %v", s.CheckInterval)
+	}
+	if s.MaxAttempts != 0 {
+		t.Errorf("Expected max_check_attempts untouched, got %d", s.MaxAttempts)
+	}
+	exp := map[string]string{"_PORT": "8080", "notes": "some notes"}
+	if !reflect.DeepEqual(s.Other, exp) {
+		t.Errorf("Expected remain %v, got %v", exp, s.Other)
+	}
+
+	co.Set("check_interval", "often")
+	if err := Unmarshal(co, &s); err == nil {
+		t.Error("Expected error for non-numeric check_interval")
+	}
+	if err := Unmarshal(co, s); err == nil {
+		t.Error("Expected error for non-pointer")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	s := testService{
+		Host:         "web01",
+		Description:  "HTTP",
+		CheckCommand: []string{"check_http", "-p 8080"},
+		Other:        map[string]string{"_PORT": "8080", "host_name": "ignored"},
+		Ignored:      "x",
+	}
+	co, err := Marshal(&s, T_SERVICE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{
+		"host_name":             "web01",
+		"service_description":   "HTTP",
+		"check_command":         "check_http!-p 8080",
+		"active_checks_enabled": "0",
+		"_PORT":                 "8080",
+	}
+	if !reflect.DeepEqual(co.Props, exp) {
+		t.Errorf("Expected %v, got %v", exp, co.Props)
+	}
+	if co.Type != T_SERVICE || co.UUID.String() == "" {
+		t.Errorf("Expected service with UUID, got %+v", co)
+	}
+
+	var s2 testService
+	if err := Unmarshal(co, &s2); err != nil {
+		t.Fatal(err)
+	}
+	s.Other = map[string]string{"_PORT": "8080"}
+	s.Ignored = ""
+	if !reflect.DeepEqual(s, s2) {
+		t.Errorf("Round-trip failed:\n%+v\n%+v", s, s2)
+	}
+
+	bad := struct {
+		X string `nagios:"no_such_key"`
+	}{"x"}
+	if _, err := Marshal(bad, T_HOST); err == nil {
+		t.Error("Expected error for invalid property")
+	}
+}
+
+func TestUnmarshalType(t *testing.T) {
+	cm, err := NewReader(strings.NewReader(yamlcfgstr)).ReadAllMap("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var svcs []*testService
+	err = cm.UnmarshalType(T_SERVICE, &svcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(svcs) != 2 {
+		t.Fatalf("Expected 2 services, got %d", len(svcs))
+	}
+	if svcs[0].CheckCommand[1] != "-p 8080" || svcs[1].CheckCommand[1] != "-p 8081" {
+		t.Errorf("Wrong order or values: %q %q", svcs[0].CheckCommand, svcs[1].CheckCommand)
+	}
+
+	type host struct {
+		Name string `nagios:"host_name"`
+	}
+	var hosts []host
+	err = cm.UnmarshalType(T_HOST, &hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 || hosts[1].Name != "web01" {
+		t.Errorf("Unexpected hosts: %+v", hosts)
+	}
+}
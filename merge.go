@@ -0,0 +1,274 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+Three-way merge of configurations, on objects instead of lines.
+Objects are matched between base, ours and theirs the same way as in Diff (see diff.go).
+For each object, a property changed on only one side takes that side's value, and a property changed the same
+way on both sides is fine too. Only when both sides changed the same property to different values, or one side
+deleted an object the other side modified, do we have a conflict. Conflicts are resolved in favour of ours
+(or in favour of keeping the modified object) in the merged config, and reported as MergeConflicts so that the
+caller can decide what to do. An object added on both sides is merged as if the base had it without properties.
+
+Objects without an identity (dependencies, escalations...) are matched on all their properties, so a changed one
+is paired with a base object of the same type not found on that side, in order. An unrelated object added where
+another is deleted may be taken as a change of it. The comment of an object is merged like a property, but never
+conflicts.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+type ConflictKind int
+
+const (
+	CONFLICT_PROP       ConflictKind = iota // both sides changed the same property to different values
+	CONFLICT_DEL_OURS                       // deleted in ours, modified in theirs
+	CONFLICT_DEL_THEIRS                     // deleted in theirs, modified in ours
+)
+
+var conflictKindNames = [...]string{
+	"property",
+	"deleted in ours",
+	"deleted in theirs",
+}
+
+// MergeConflict describes a single conflict from Merge3. Key, Base, Ours and Theirs are only set for CONFLICT_PROP.
+// Empty values mean the property is not set on that side.
+type MergeConflict struct {
+	Kind   ConflictKind
+	Type   CfgType
+	ID     string
+	Key    string
+	Base   string
+	Ours   string
+	Theirs string
+}
+
+func (ck ConflictKind) String() string {
+	return conflictKindNames[ck]
+}
+
+// mergeProps merges the properties of o and t into res, with b as the common base.
+// Conflicting keys keep the value from o. b can be nil.
+func mergeProps(res, b, o, t *CfgObj) []string {
+	var bp map[string]string
+	if b != nil {
+		bp = b.Props
+	}
+	keys := make(map[string]bool)
+	for _, m := range []map[string]string{bp, o.Props, t.Props} {
+		for k := range m {
+			keys[k] = true
+		}
+	}
+	var conflicts []string
+	for k := range keys {
+		bv, ov, tv := bp[k], o.Props[k], t.Props[k]
+		var val string
+		switch {
+		case ov == tv, tv == bv:
+			val = ov
+		case ov == bv:
+			val = tv
+		default:
+			val = ov
+			conflicts = append(conflicts, k)
+		}
+		if val == "" {
+			delete(res.Props, k)
+		} else {
+			res.Props[k] = val
+		}
+	}
+	res.Type.sortKeys(conflicts)
+	return conflicts
+}
+
+// mergeTriple is an object as matched between base, ours and theirs. Any of them can be nil.
+type mergeTriple struct {
+	b, o, t *CfgObj
+}
+
+// pairUnnamed pairs objects without an identity (dependencies, escalations...) on one side with the base
+// object they were changed from. Such objects are matched on all their properties, so a changed one is not
+// found in base. Base objects not found on the side are paired with the objects on the side not found in base,
+// by type and in order, so that changes on both sides are merged, or reported as conflicts, instead of keeping
+// both versions. side returns the side's object in a triple.
+func pairUnnamed(triples []*mergeTriple, side func(mt *mergeTriple) **CfgObj) {
+	lost := make(map[CfgType][]*mergeTriple)  // base object not on the side
+	found := make(map[CfgType][]*mergeTriple) // object on the side not in base
+	for _, mt := range triples {
+		x := *side(mt)
+		switch {
+		case mt.b != nil && x == nil:
+			if _, named := mt.b.Identity(); !named {
+				lost[mt.b.Type] = append(lost[mt.b.Type], mt)
+			}
+		case mt.b == nil && x != nil:
+			if _, named := x.Identity(); !named {
+				found[x.Type] = append(found[x.Type], mt)
+			}
+		}
+	}
+	for ct, bs := range lost {
+		xs := found[ct]
+		for i := 0; i < len(bs) && i < len(xs); i++ {
+			*side(bs[i]), *side(xs[i]) = *side(xs[i]), nil
+		}
+	}
+}
+
+// Merge3 merges the changes made from base to ours and from base to theirs.
+// The merged config holds copies of the objects from ours (or theirs, for objects only there),
+// with the same UUIDs, so that the original order is kept.
+func Merge3(base, ours, theirs CfgMap) (CfgMap, []*MergeConflict) {
+	res := make(CfgMap)
+	var conflicts []*MergeConflict
+	bidx, border := diffIndex(base)
+	oidx, oorder := diffIndex(ours)
+	tidx, torder := diffIndex(theirs)
+
+	// merge each key only once, in the order of ours first, then theirs and base
+	seen := make(map[string]bool)
+	order := make([]string, 0, len(oorder)+len(torder))
+	for _, lst := range [][]string{oorder, torder, border} {
+		for _, key := range lst {
+			if !seen[key] {
+				seen[key] = true
+				order = append(order, key)
+			}
+		}
+	}
+
+	get := func(cos CfgObjs, i int) *CfgObj {
+		if i < len(cos) {
+			return cos[i]
+		}
+		return nil
+	}
+	modified := func(b, x *CfgObj) bool {
+		return len(propChanges(b.Type, b, x)) > 0
+	}
+
+	var triples []*mergeTriple
+	for _, key := range order {
+		bobjs, oobjs, tobjs := bidx[key], oidx[key], tidx[key]
+		n := len(bobjs)
+		if len(oobjs) > n {
+			n = len(oobjs)
+		}
+		if len(tobjs) > n {
+			n = len(tobjs)
+		}
+		for i := 0; i < n; i++ {
+			triples = append(triples, &mergeTriple{b: get(bobjs, i), o: get(oobjs, i), t: get(tobjs, i)})
+		}
+	}
+	pairUnnamed(triples, func(mt *mergeTriple) **CfgObj { return &mt.o })
+	pairUnnamed(triples, func(mt *mergeTriple) **CfgObj { return &mt.t })
+
+	for _, mt := range triples {
+		b, o, t := mt.b, mt.o, mt.t
+		var keep *CfgObj
+		switch {
+		case o == nil && t == nil: // deleted on both sides
+		case t == nil && b == nil: // added in ours
+			keep = o.Copy()
+		case o == nil && b == nil: // added in theirs
+			keep = t.Copy()
+		case t == nil: // deleted in theirs
+			if modified(b, o) {
+				keep = o.Copy()
+				conflicts = append(conflicts, &MergeConflict{Kind: CONFLICT_DEL_THEIRS, Type: o.Type, ID: diffID(o)})
+			}
+		case o == nil: // deleted in ours
+			if modified(b, t) {
+				keep = t.Copy()
+				conflicts = append(conflicts, &MergeConflict{Kind: CONFLICT_DEL_OURS, Type: t.Type, ID: diffID(t)})
+			}
+		default:
+			keep = o.Copy()
+			if b != nil && o.Comment == b.Comment {
+				keep.Comment, keep.keepCmt = t.Comment, t.keepCmt
+			}
+			for _, k := range mergeProps(keep, b, o, t) {
+				mc := &MergeConflict{
+					Kind:   CONFLICT_PROP,
+					Type:   o.Type,
+					ID:     diffID(o),
+					Key:    k,
+					Ours:   o.Props[k],
+					Theirs: t.Props[k],
+				}
+				if b != nil {
+					mc.Base = b.Props[k]
+				}
+				conflicts = append(conflicts, mc)
+			}
+		}
+		if keep != nil {
+			res[keep.UUID] = keep
+		}
+	}
+	return res, conflicts
+}
+
+// Merge3 merges the changes made from base to ours and from base to theirs into a new NagiosCfg.
+// See the package level Merge3 for details.
+func (nc *NagiosCfg) Merge3(base, theirs *NagiosCfg) (*NagiosCfg, []*MergeConflict) {
+	cm, conflicts := Merge3(base.Config, nc.Config, theirs.Config)
+	res := NewNagiosCfg()
+	res.Config = cm
+	return res, conflicts
+}
+
+// Print writes a single line describing the conflict
+func (mc *MergeConflict) Print(w io.Writer) error {
+	var err error
+	if mc.Kind == CONFLICT_PROP {
+		_, err = fmt.Fprintf(w, "%s %s: %s: base %q, ours %q, theirs %q\n", mc.Type.String(), mc.ID, mc.Key, mc.Base, mc.Ours, mc.Theirs)
+	} else {
+		_, err = fmt.Fprintf(w, "%s %s: %s, but modified on the other side\n", mc.Type.String(), mc.ID, mc.Kind.String())
+	}
+	return err
+}
+
+func (mc *MergeConflict) MarshalJSON() ([]byte, error) {
+	tmp := struct {
+		Kind   string `json:"kind"`
+		Type   string `json:"type"`
+		ID     string `json:"id"`
+		Key    string `json:"key,omitempty"`
+		Base   string `json:"base,omitempty"`
+		Ours   string `json:"ours,omitempty"`
+		Theirs string `json:"theirs,omitempty"`
+	}{
+		Kind:   mc.Kind.String(),
+		Type:   mc.Type.String(),
+		ID:     mc.ID,
+		Key:    mc.Key,
+		Base:   mc.Base,
+		Ours:   mc.Ours,
+		Theirs: mc.Theirs,
+	}
+	return json.Marshal(tmp)
+}
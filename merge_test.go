@@ -0,0 +1,202 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var mergebase string = `define host{
+	host_name web01
+	address 10.0.0.1
+	alias Web 1
+}
+define host{
+	host_name web02
+	address 10.0.0.2
+}
+define host{
+	host_name web03
+	address 10.0.0.3
+}
+define service{
+	host_name web01
+	service_description HTTP
+	check_command check_http
+	max_check_attempts 3
+}
+`
+
+var mergeours string = `define host{
+	host_name web01
+	address 10.0.0.11
+	alias Web 1
+}
+define host{
+	host_name web03
+	address 10.0.0.3
+	notes modified in ours
+}
+define service{
+	host_name web01
+	service_description HTTP
+	check_command check_http!-p 8080
+	max_check_attempts 3
+}
+define command{
+	command_name check_ours
+	command_line /bin/true
+}
+`
+
+var mergetheirs string = `define host{
+	host_name web01
+	address 10.0.0.1
+	alias Web One
+}
+define host{
+	host_name web02
+	address 10.0.0.2
+}
+define service{
+	host_name web01
+	service_description HTTP
+	check_command check_http!-p 8081
+	max_check_attempts 5
+}
+define command{
+	command_name check_theirs
+	command_line /bin/false
+}
+`
+
+func TestMerge3(t *testing.T) {
+	base := loadDiffCfg(t, mergebase)
+	ours := loadDiffCfg(t, mergeours)
+	theirs := loadDiffCfg(t, mergetheirs)
+
+	res, conflicts := ours.Merge3(base, theirs)
+
+	byID := make(map[string]*CfgObj)
+	for _, k := range res.Config.Keys() {
+		byID[diffID(res.Config[k])] = res.Config[k]
+	}
+	if len(byID) != 5 {
+		t.Errorf("Expected 5 objects, got %d: %v", len(byID), byID)
+	}
+
+	// changes to different properties on each side
+	web01 := byID["web01"]
+	if web01 == nil || web01.Props["address"] != "10.0.0.11" || web01.Props["alias"] != "Web One" {
+		t.Errorf("Expected both changes to web01, got %+v", web01)
+	}
+	// deleted in ours, untouched in theirs
+	if _, found := byID["web02"]; found {
+		t.Error("Expected web02 to be deleted")
+	}
+	// deleted in theirs, modified in ours
+	if _, found := byID["web03"]; !found {
+		t.Error("Expected web03 to be kept, as it was modified in ours")
+	}
+	// added on each side
+	if byID["check_ours"] == nil || byID["check_theirs"] == nil {
+		t.Error("Expected commands added on both sides")
+	}
+	// conflicting check_command, but max_check_attempts only changed in theirs
+	svc := byID["web01;HTTP"]
+	if svc.Props["check_command"] != "check_http!-p 8080" || svc.Props["max_check_attempts"] != "5" {
+		t.Errorf("Unexpected service: %+v", svc.Props)
+	}
+
+	if len(conflicts) != 2 {
+		t.Fatalf("Expected 2 conflicts, got %d", len(conflicts))
+	}
+	var prop, del *MergeConflict
+	for _, c := range conflicts {
+		switch c.Kind {
+		case CONFLICT_PROP:
+			prop = c
+		case CONFLICT_DEL_THEIRS:
+			del = c
+		}
+	}
+	if prop == nil || prop.Key != "check_command" || prop.Base != "check_http" || prop.Theirs != "check_http!-p 8081" {
+		t.Errorf("Unexpected property conflict: %+v", prop)
+	}
+	if del == nil || del.ID != "web03" {
+		t.Errorf("Unexpected delete conflict: %+v", del)
+	}
+
+	b, err := json.Marshal(conflicts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"kind":"property"`) {
+		t.Errorf("Unexpected JSON: %s", b)
+	}
+
+	// the same change on both sides is no conflict
+	_, conflicts = Merge3(base.Config, ours.Config, ours.Config)
+	if len(conflicts) != 0 {
+		t.Errorf("Expected no conflicts, got %d", len(conflicts))
+	}
+}
+
+func TestMerge3Unnamed(t *testing.T) {
+	dep := `define hostdependency{
+	host_name web01
+	dependent_host_name web02
+	notification_failure_criteria %s
+}
+define hostescalation{
+	host_name web01
+	first_notification %s
+}
+`
+	base := loadDiffCfg(t, fmt.Sprintf(dep, "d", "1"))
+	ours := loadDiffCfg(t, fmt.Sprintf(dep, "d,u", "2"))
+	theirs := loadDiffCfg(t, fmt.Sprintf(dep, "d,u,f", "1"))
+
+	res, conflicts := Merge3(base.Config, ours.Config, theirs.Config)
+	if len(res) != 2 {
+		t.Errorf("Expected the changed objects paired with base, got %d objects", len(res))
+	}
+	if len(conflicts) != 1 || conflicts[0].Kind != CONFLICT_PROP || conflicts[0].Key != "notification_failure_criteria" ||
+		conflicts[0].Base != "d" || conflicts[0].Ours != "d,u" || conflicts[0].Theirs != "d,u,f" {
+		t.Errorf("Expected one conflict on the dependency, got %v", conflicts)
+	}
+	for _, co := range res {
+		if co.Type == T_HOSTESCALATION && co.Props["first_notification"] != "2" {
+			t.Errorf("Expected the change in ours to the escalation, got %v", co.Props)
+		}
+	}
+}
+
+func TestMerge3Comments(t *testing.T) {
+	base := loadDiffCfg(t, "define host{\n\thost_name web01\n}\n")
+	ours := loadDiffCfg(t, "# ours\ndefine host{\n\thost_name web01\n\taddress 10.0.0.1\n}\n")
+	theirs := loadDiffCfg(t, "define host{\n\thost_name web01\n}\n")
+	res, _ := Merge3(base.Config, ours.Config, theirs.Config)
+	for _, co := range res {
+		if co.Comment != "# ours" {
+			t.Errorf("Expected the comment from ours kept, got %q", co.Comment)
+		}
+	}
+}
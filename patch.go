@@ -0,0 +1,369 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+Patch documents, describing edits to a config in a way that can be reviewed, stored and undone.
+A patch is a list of operations, in JSON or YAML, like this:
+
+	{"ops": [
+	  {"op": "add", "type": "command", "props": {"command_name": "check_foo", "command_line": "/bin/true"}},
+	  {"op": "delete", "type": "host", "id": "web02"},
+	  {"op": "set", "type": "service", "match": {"host_name": "^web"}, "props": {"max_check_attempts": "5"}},
+	  {"op": "del", "type": "service", "id": "web01;HTTP", "keys": ["notes"]},
+	  {"op": "replace", "type": "host", "key": "address", "regex": "^10\\.0\\.", "value": "10.1."}
+	]}
+
+All ops except add need a selector: any combination of type, id (the name the object is known by, as in
+Diff) and match (property regexes, all of which must match). A selector that matches no objects is an error.
+
+A patch is applied atomically: it is first tried on a copy of the config, and only applied for real when all
+ops succeeded there. The copy shares unchanged objects with the config, and only the objects the patch changes
+are copied, like with snapshots (see snapshot.go). Applying a patch returns its inverse, which undoes all changes
+when applied to the result.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"regexp"
+)
+
+const (
+	PATCH_ADD     string = "add"     // add a new object
+	PATCH_DELETE  string = "delete"  // delete the selected objects
+	PATCH_SET     string = "set"     // set props on the selected objects
+	PATCH_DEL     string = "del"     // delete keys from the selected objects
+	PATCH_REPLACE string = "replace" // regex replace in the value of key for the selected objects
+)
+
+// PatchOp is a single patch operation. Which fields are used depends on Op, see the top of this file.
+type PatchOp struct {
+	Op     string            `json:"op" yaml:"op"`
+	Type   string            `json:"type,omitempty" yaml:"type,omitempty"`
+	ID     string            `json:"id,omitempty" yaml:"id,omitempty"`
+	Match  map[string]string `json:"match,omitempty" yaml:"match,omitempty"`
+	FileID string            `json:"fileid,omitempty" yaml:"fileid,omitempty"`
+	Props  map[string]string `json:"props,omitempty" yaml:"props,omitempty"`
+	Keys   []string          `json:"keys,omitempty" yaml:"keys,omitempty"`
+	Key    string            `json:"key,omitempty" yaml:"key,omitempty"`
+	Regex  string            `json:"regex,omitempty" yaml:"regex,omitempty"`
+	Value  string            `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+type Patch struct {
+	Ops []PatchOp `json:"ops" yaml:"ops"`
+}
+
+// ParsePatch reads a patch in JSON or YAML format
+func ParsePatch(b []byte) (*Patch, error) {
+	p := &Patch{}
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		err = json.Unmarshal(b, p)
+	} else {
+		err = yaml.Unmarshal(b, p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (op *PatchOp) String() string {
+	return fmt.Sprintf("%s %s %s", op.Op, op.Type, op.ID)
+}
+
+// cfgType returns the type given for op, or T_INVALID if none
+func (op *PatchOp) cfgType() (CfgType, error) {
+	if op.Type == "" {
+		return T_INVALID, nil
+	}
+	cn := CfgName(op.Type)
+	if !cn.Valid() {
+		return T_INVALID, fmt.Errorf("Invalid object type: %q %s", op.Type, dbgStr(true))
+	}
+	return cn.Type(), nil
+}
+
+// selectObjs returns the objects selected by op, in original order
+func (op *PatchOp) selectObjs(cm CfgMap) (UUIDs, error) {
+	if op.Type == "" && op.ID == "" && len(op.Match) == 0 {
+		return nil, fmt.Errorf("Missing selector, need at least one of type, id or match %s", dbgStr(true))
+	}
+	ct, err := op.cfgType()
+	if err != nil {
+		return nil, err
+	}
+	rxs := make(map[string]*regexp.Regexp, len(op.Match))
+	for k, re := range op.Match {
+		rx, err := regexp.Compile(re)
+		if err != nil {
+			return nil, err
+		}
+		rxs[k] = rx
+	}
+
+	var ids UUIDs
+	for _, k := range cm.Keys() {
+		co := cm[k]
+		if ct != T_INVALID && co.Type != ct {
+			continue
+		}
+		if op.ID != "" && diffID(co) != op.ID {
+			continue
+		}
+		matched := true
+		for key, rx := range rxs {
+			val, found := co.Get(key)
+			if !found || !rx.MatchString(val) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			ids = append(ids, k)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("Selector matches no objects %s", dbgStr(true))
+	}
+	return ids, nil
+}
+
+// restoreOps returns the ops needed to give co back the values in old, where an empty value means the key
+// did not exist. Each op selects the object by the ID it has when that op runs, as restoring identity keys
+// changes it.
+func restoreOps(co *CfgObj, old map[string]string) []PatchOp {
+	set := make(map[string]string)
+	var del []string
+	for k, v := range old {
+		if v == "" {
+			del = append(del, k)
+		} else {
+			set[k] = v
+		}
+	}
+	var ops []PatchOp
+	c := co.Copy() // to follow the ID through the ops
+	if len(del) > 0 {
+		co.Type.sortKeys(del)
+		ops = append(ops, PatchOp{Op: PATCH_DEL, Type: co.Type.String(), ID: diffID(c), Keys: del})
+		for _, k := range del {
+			c.Del(k)
+		}
+	}
+	if len(set) > 0 {
+		ops = append(ops, PatchOp{Op: PATCH_SET, Type: co.Type.String(), ID: diffID(c), Props: set})
+	}
+	return ops
+}
+
+// patchTarget is the map a patch is applied to. For a dry run, it's a shallow copy of the config, where objects
+// are copied the first time they are changed, so that the config is left alone without copying all of it.
+type patchTarget struct {
+	cm     CfgMap
+	dry    bool
+	copied uuidSet
+}
+
+// dryRun returns a target for trying out a patch on cm
+func dryRun(cm CfgMap) *patchTarget {
+	return &patchTarget{cm: cm.shallowCopy(), dry: true, copied: make(uuidSet)}
+}
+
+// writable returns the object with UUID u, ready to be changed, or nil if not found
+func (pt *patchTarget) writable(u UUID) *CfgObj {
+	if !pt.dry {
+		return pt.cm.writable(u)
+	}
+	co, found := pt.cm[u]
+	if !found || co == nil {
+		return nil
+	}
+	if _, found := pt.copied[u]; !found {
+		co = co.Copy()
+		pt.cm[u] = co
+		pt.copied[u] = struct{}{}
+	}
+	return co
+}
+
+// apply applies op to the target, and returns the ops that undo it
+func (op *PatchOp) apply(pt *patchTarget) ([]PatchOp, error) {
+	cm := pt.cm
+	if op.Op == PATCH_ADD {
+		ct, err := op.cfgType()
+		if err != nil {
+			return nil, err
+		}
+		if ct == T_INVALID || len(op.Props) == 0 {
+			return nil, fmt.Errorf("Both type and props are needed to add an object %s", dbgStr(true))
+		}
+		co := NewCfgObjWithUUID(ct)
+		co.FileID = op.FileID
+		for k, v := range op.Props {
+			if !IsValidProperty(k) {
+				return nil, fmt.Errorf("Invalid property: %q %s", k, dbgStr(true))
+			}
+			co.Set(k, v)
+		}
+		cm.AddByUUID(co.UUID, co)
+		if !pt.dry {
+			addOrder(co.UUID) // objects that never make it into the config don't belong in the read order
+		}
+		return []PatchOp{{Op: PATCH_DELETE, Type: op.Type, ID: diffID(co)}}, nil
+	}
+
+	ids, err := op.selectObjs(cm)
+	if err != nil {
+		return nil, err
+	}
+
+	var rx *regexp.Regexp
+	switch op.Op {
+	case PATCH_DELETE:
+	case PATCH_SET:
+		for k, v := range op.Props {
+			if !IsValidProperty(k) {
+				return nil, fmt.Errorf("Invalid property: %q %s", k, dbgStr(true))
+			}
+			if v == "" {
+				return nil, fmt.Errorf("Empty value for %q, use %q to remove keys %s", k, PATCH_DEL, dbgStr(true))
+			}
+		}
+	case PATCH_DEL:
+		if len(op.Keys) == 0 {
+			return nil, fmt.Errorf("No keys given to delete %s", dbgStr(true))
+		}
+	case PATCH_REPLACE:
+		if op.Key == "" {
+			return nil, fmt.Errorf("No key given to replace in %s", dbgStr(true))
+		}
+		rx, err = regexp.Compile(op.Regex)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Unknown op: %q %s", op.Op, dbgStr(true))
+	}
+
+	var inv []PatchOp
+	for _, id := range ids {
+		co := pt.writable(id)
+		old := make(map[string]string)
+		switch op.Op {
+		case PATCH_DELETE:
+			props := make(map[string]string, len(co.Props))
+			for k, v := range co.Props {
+				props[k] = v
+			}
+			cm.DelByUUID(id)
+			inv = append(inv, PatchOp{Op: PATCH_ADD, Type: co.Type.String(), FileID: co.FileID, Props: props})
+			continue
+		case PATCH_SET:
+			for k, v := range op.Props {
+				if co.Props[k] != v {
+					old[k] = co.Props[k]
+					co.Set(k, v)
+				}
+			}
+		case PATCH_DEL:
+			for _, k := range op.Keys {
+				if val, found := co.Get(k); found {
+					old[k] = val
+					co.Del(k)
+				}
+			}
+		case PATCH_REPLACE:
+			val, found := co.Get(op.Key)
+			if !found {
+				continue
+			}
+			nval := rx.ReplaceAllString(val, op.Value)
+			if nval == val {
+				continue
+			}
+			if nval == "" {
+				return nil, fmt.Errorf("Replace leaves %q empty for %s %s %s", op.Key, co.Type.String(), diffID(co), dbgStr(true))
+			}
+			old[op.Key] = val
+			co.Set(op.Key, nval)
+		}
+		inv = append(inv, restoreOps(co, old)...)
+	}
+	return inv, nil
+}
+
+// apply applies all ops to the target, and returns the inverse patch. The target is left half way on errors.
+func (p *Patch) apply(pt *patchTarget) (*Patch, error) {
+	inv := &Patch{}
+	for i := range p.Ops {
+		ops, err := p.Ops[i].apply(pt)
+		if err != nil {
+			return nil, fmt.Errorf("Op #%d (%s): %s", i, p.Ops[i].String(), err)
+		}
+		inv.Ops = append(ops, inv.Ops...) // undo in reverse order
+	}
+	return inv, nil
+}
+
+// Preview returns what would change if the patch was applied to cm, without touching cm
+func (p *Patch) Preview(cm CfgMap) (*CfgDiff, error) {
+	pt := dryRun(cm)
+	_, err := p.apply(pt)
+	if err != nil {
+		return nil, err
+	}
+	return diffShared(cm, pt.cm), nil
+}
+
+// Apply applies all ops in the patch, or none of them if any fails, and returns the patch that undoes it.
+// The patch is first tried on a copy-on-write view of cm, and only applied to cm when that succeeded.
+func (p *Patch) Apply(cm CfgMap) (*Patch, error) {
+	_, err := p.apply(dryRun(cm))
+	if err != nil {
+		return nil, err
+	}
+	defer beginBatch(cm, "Patch")()
+	return p.apply(&patchTarget{cm: cm}) // same ops on the same objects, so this can't fail now
+}
+
+// ApplyPatch applies p to the config, see Patch.Apply. Deleted objects are removed from the current matches.
+func (nc *NagiosCfg) ApplyPatch(p *Patch) (*Patch, error) {
+	inv, err := p.Apply(nc.Config)
+	if err != nil {
+		return nil, err
+	}
+	if nc.matches != nil {
+		matches := make(UUIDs, 0, len(nc.matches))
+		for _, u := range nc.matches {
+			if _, found := nc.Config[u]; found {
+				matches = append(matches, u)
+			}
+		}
+		nc.matches = matches
+	}
+	return inv, nil
+}
+
+// PreviewPatch returns what would change if p was applied to the config
+func (nc *NagiosCfg) PreviewPatch(p *Patch) (*CfgDiff, error) {
+	return p.Preview(nc.Config)
+}
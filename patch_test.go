@@ -0,0 +1,204 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+var patchjson string = `{"ops": [
+	{"op": "add", "type": "command", "props": {"command_name": "check_foo", "command_line": "/bin/true"}},
+	{"op": "delete", "type": "hostdependency", "match": {"dependent_host_name": "^web02$"}},
+	{"op": "set", "type": "service", "match": {"host_name": "^web"}, "props": {"max_check_attempts": "5", "notes": "patched"}},
+	{"op": "del", "type": "host", "id": "web01", "keys": ["alias", "notes"]},
+	{"op": "replace", "type": "host", "key": "address", "regex": "^10\\.0\\.", "value": "10.1."}
+]}`
+
+func TestPatchApply(t *testing.T) {
+	nc := loadDiffCfg(t, diffcfgstr_a)
+	orig := nc.Config.Copy()
+
+	p, err := ParsePatch([]byte(patchjson))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := nc.PreviewPatch(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Added) != 1 || len(d.Removed) != 1 || len(d.Modified) != 2 {
+		var buf bytes.Buffer
+		d.Print(&buf)
+		t.Errorf("Unexpected preview:\n%s", buf.String())
+	}
+	if !DiffMaps(orig, nc.Config).Empty() {
+		t.Fatal("Preview modified the config")
+	}
+
+	inv, err := nc.ApplyPatch(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := make(map[string]*CfgObj)
+	for _, k := range nc.Config.Keys() {
+		byID[diffID(nc.Config[k])] = nc.Config[k]
+	}
+	host := byID["web01"]
+	if host == nil || host.Props["address"] != "10.1.0.1" || host.Props["alias"] != "" {
+		t.Errorf("Unexpected host: %+v", host)
+	}
+	svc := byID["web01;HTTP"]
+	if svc == nil || svc.Props["max_check_attempts"] != "5" || svc.Props["notes"] != "patched" {
+		t.Errorf("Unexpected service: %+v", svc)
+	}
+	if byID["check_foo"] == nil {
+		t.Error("Expected command check_foo to be added")
+	}
+
+	b, _ := json.Marshal(inv)
+	t.Logf("Inverse: %s", b)
+	_, err = nc.ApplyPatch(inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := DiffMaps(orig, nc.Config); !d.Empty() {
+		var buf bytes.Buffer
+		d.Print(&buf)
+		t.Errorf("Inverse patch did not restore the config:\n%s", buf.String())
+	}
+}
+
+func TestPatchInverseIdentity(t *testing.T) {
+	nc := loadDiffCfg(t, `define hostdependency{
+	host_name web01
+	dependent_host_name web02
+}
+define host{
+	host_name web01
+	address 10.0.0.1
+}
+`)
+	orig := nc.Config.Copy()
+	p, err := ParsePatch([]byte(`{"ops": [
+	{"op": "set", "type": "hostdependency", "id": "host_name=web01;dependent_host_name=web02", "props": {"host_name": "web03", "hostgroup_name": "g1"}},
+	{"op": "del", "type": "hostdependency", "match": {"host_name": "^web03$"}, "keys": ["dependent_host_name"]},
+	{"op": "replace", "type": "host", "key": "host_name", "regex": "01", "value": "04"}
+]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, err := nc.ApplyPatch(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nc.ApplyPatch(inv); err != nil {
+		t.Fatalf("Inverse patch failed: %s", err)
+	}
+	if d := DiffMaps(orig, nc.Config); !d.Empty() {
+		var buf bytes.Buffer
+		d.Print(&buf)
+		t.Errorf("Expected the original config back, got:\n%s", buf.String())
+	}
+}
+
+func TestPatchAtomic(t *testing.T) {
+	nc := loadDiffCfg(t, diffcfgstr_a)
+	orig := nc.Config.Copy()
+
+	p, err := ParsePatch([]byte(`
+ops:
+  - op: set
+    type: host
+    id: web01
+    props:
+      notes: first op is fine
+  - op: delete
+    type: host
+    id: no-such-host
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Ops) != 2 || p.Ops[0].Props["notes"] != "first op is fine" {
+		t.Fatalf("Unexpected patch from YAML: %+v", p)
+	}
+	_, err = nc.ApplyPatch(p)
+	if err == nil {
+		t.Fatal("Expected error for selector without matches")
+	}
+	if !DiffMaps(orig, nc.Config).Empty() {
+		t.Error("Failed patch modified the config")
+	}
+
+	bad := []string{
+		`{"ops": [{"op": "set", "props": {"notes": "x"}}]}`,
+		`{"ops": [{"op": "set", "type": "host", "props": {"no_such_key": "x"}}]}`,
+		`{"ops": [{"op": "add", "type": "host"}]}`,
+		`{"ops": [{"op": "frob", "type": "host"}]}`,
+		`{"ops": [{"op": "replace", "type": "host", "key": "address", "regex": ".*", "value": ""}]}`,
+	}
+	for _, s := range bad {
+		p, err := ParsePatch([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := nc.ApplyPatch(p); err == nil {
+			t.Errorf("Expected error for %s", s)
+		}
+	}
+}
+
+func TestPatchPreview(t *testing.T) {
+	nc := loadDiffCfg(t, diffcfgstr_a)
+	p, err := ParsePatch([]byte(patchjson))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs := nc.Config.shallowCopy()
+	orig := nc.Config.Copy()
+	order := len(readOrder())
+
+	diff, err := p.Preview(nc.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readOrder()) != order {
+		t.Error("Preview should not add to the read order")
+	}
+	for u, co := range objs {
+		if nc.Config[u] != co {
+			t.Errorf("Preview replaced %s %s", co.Type.String(), diffID(co))
+		}
+	}
+	if !DiffMaps(orig, nc.Config).Empty() {
+		t.Error("Preview modified the config")
+	}
+
+	if _, err := nc.ApplyPatch(p); err != nil {
+		t.Fatal(err)
+	}
+	if len(readOrder()) != order+1 {
+		t.Errorf("Expected only the added object in the read order, got %d more", len(readOrder())-order)
+	}
+	applied := DiffMaps(orig, nc.Config)
+	if len(diff.Added) != len(applied.Added) || len(diff.Removed) != len(applied.Removed) || len(diff.Modified) != len(applied.Modified) {
+		t.Errorf("Preview %+v differs from the applied patch %+v", diff, applied)
+	}
+}
@@ -0,0 +1,427 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+A small query language for selecting objects, as an alternative to CfgQuery. Example:
+
+	type=service AND host_name=~"^web" AND NOT (check_command=~ping OR EXISTS notes)
+
+Expressions are built from comparisons, combined with AND, OR, NOT and parentheses. AND binds harder than OR.
+Keywords are case insensitive. A comparison is a key, an operator and a value:
+
+	=   equal                    !=  not equal, or key missing
+	=~  regex match              !~  no regex match, or key missing
+	<   <=  >  >=                numeric comparison, false if the value of the key is not a number
+	EXISTS key                   the key is set
+
+Besides object properties, the key can be "type" for the object type, or "fileid" for the file it was read from.
+Values are either a bare word, ending at whitespace or a parenthesis, or a double quoted string, where \" and
+\\ are the only escapes.
+*/
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	Q_KEY_TYPE   string = "type"   // pseudo key for the object type
+	Q_KEY_FILEID string = "fileid" // pseudo key for the FileID
+)
+
+// Query is a compiled query expression, see ParseQuery
+type Query struct {
+	src  string
+	root qnode
+}
+
+// qnode is a node in the parsed expression tree
+type qnode interface {
+	match(v queryValuer) bool
+}
+
+// queryValuer gives the values a query is evaluated against, so that the same query can be used on the
+// properties of the object itself, or on the template resolved ones (see resolve.go)
+type queryValuer interface {
+	value(key string) (string, bool)
+}
+
+// objValuer gives the values of the object itself
+type objValuer struct {
+	co *CfgObj
+}
+
+type qAnd []qnode
+type qOr []qnode
+type qNot struct{ n qnode }
+
+type qExists struct{ key string }
+
+type qCmp struct {
+	key string
+	op  string
+	val string
+	rx  *regexp.Regexp
+	num float64
+}
+
+// queryParser is a recursive descent parser, working directly on the input string
+type queryParser struct {
+	s   string
+	pos int
+}
+
+// ParseQuery compiles the given expression, or returns an error pointing out where it failed
+func ParseQuery(s string) (*Query, error) {
+	p := &queryParser{s: s}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return nil, p.errorf("Unexpected %q", p.s[p.pos:])
+	}
+	return &Query{src: s, root: n}, nil
+}
+
+// MustParseQuery is like ParseQuery, but panics on errors. For queries given in code.
+func MustParseQuery(s string) *Query {
+	q, err := ParseQuery(s)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+func (q *Query) String() string {
+	return q.src
+}
+
+// Match returns true if co satisfies the query
+func (q *Query) Match(co *CfgObj) bool {
+	return q.root.match(objValuer{co})
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Query error at position %d: %s %s", p.pos, fmt.Sprintf(format, args...), dbgStr(true))
+}
+
+func (p *queryParser) skipSpace() {
+	for p.pos < len(p.s) && strings.ContainsRune(" \t\r\n", rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+// keyword consumes the given keyword if it's next in the input, followed by a word boundary
+func (p *queryParser) keyword(kw string) bool {
+	p.skipSpace()
+	end := p.pos + len(kw)
+	if end > len(p.s) || !strings.EqualFold(p.s[p.pos:end], kw) {
+		return false
+	}
+	if end < len(p.s) && isQueryKeyChar(p.s[end]) {
+		return false // just the start of a longer word, e.g. a key named "order"
+	}
+	p.pos = end
+	return true
+}
+
+func isQueryKeyChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *queryParser) parseOr() (qnode, error) {
+	n, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	or := qOr{n}
+	for p.keyword("OR") {
+		n, err = p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, n)
+	}
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return or, nil
+}
+
+func (p *queryParser) parseAnd() (qnode, error) {
+	n, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	and := qAnd{n}
+	for p.keyword("AND") {
+		n, err = p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, n)
+	}
+	if len(and) == 1 {
+		return and[0], nil
+	}
+	return and, nil
+}
+
+func (p *queryParser) parseNot() (qnode, error) {
+	if p.keyword("NOT") {
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return qNot{n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (qnode, error) {
+	p.skipSpace()
+	if p.pos >= len(p.s) {
+		return nil, p.errorf("Unexpected end of query")
+	}
+	if p.s[p.pos] == '(' {
+		p.pos++
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos >= len(p.s) || p.s[p.pos] != ')' {
+			return nil, p.errorf("Missing )")
+		}
+		p.pos++
+		return n, nil
+	}
+	if p.keyword("EXISTS") {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		return qExists{key}, nil
+	}
+	return p.parseCmp()
+}
+
+func (p *queryParser) parseKey() (string, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && isQueryKeyChar(p.s[p.pos]) {
+		p.pos++
+	}
+	key := p.s[start:p.pos]
+	if key == "" {
+		return "", p.errorf("Expected key")
+	}
+	if key != Q_KEY_TYPE && key != Q_KEY_FILEID && !IsValidProperty(key) {
+		p.pos = start
+		return "", p.errorf("Invalid key: %q", key)
+	}
+	return key, nil
+}
+
+func (p *queryParser) parseOp() (string, error) {
+	p.skipSpace()
+	// longest first, so that "=~" is not taken as "="
+	for _, op := range []string{"=~", "!~", "!=", "<=", ">=", "=", "<", ">"} {
+		if strings.HasPrefix(p.s[p.pos:], op) {
+			p.pos += len(op)
+			return op, nil
+		}
+	}
+	return "", p.errorf("Expected one of = != =~ !~ < <= > >=")
+}
+
+func (p *queryParser) parseValue() (string, error) {
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == '"' {
+		p.pos++
+		var b strings.Builder
+		for p.pos < len(p.s) {
+			c := p.s[p.pos]
+			p.pos++
+			switch {
+			case c == '"':
+				return b.String(), nil
+			case c == '\\' && p.pos < len(p.s) && (p.s[p.pos] == '"' || p.s[p.pos] == '\\'):
+				b.WriteByte(p.s[p.pos])
+				p.pos++
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", p.errorf("Unterminated string")
+	}
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune(" \t\r\n()", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	if start == p.pos {
+		return "", p.errorf("Expected value")
+	}
+	return p.s[start:p.pos], nil
+}
+
+func (p *queryParser) parseCmp() (qnode, error) {
+	key, err := p.parseKey()
+	if err != nil {
+		return nil, err
+	}
+	op, err := p.parseOp()
+	if err != nil {
+		return nil, err
+	}
+	vpos := p.pos
+	val, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	c := qCmp{key: key, op: op, val: val}
+	switch op {
+	case "=~", "!~":
+		c.rx, err = regexp.Compile(val)
+		if err != nil {
+			p.pos = vpos
+			return nil, p.errorf("%s", err)
+		}
+	case "<", "<=", ">", ">=":
+		c.num, err = strconv.ParseFloat(val, 64)
+		if err != nil {
+			p.pos = vpos
+			return nil, p.errorf("Not a number: %q", val)
+		}
+	}
+	return c, nil
+}
+
+func (n qAnd) match(v queryValuer) bool {
+	for i := range n {
+		if !n[i].match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n qOr) match(v queryValuer) bool {
+	for i := range n {
+		if n[i].match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n qNot) match(v queryValuer) bool {
+	return !n.n.match(v)
+}
+
+// pseudoValue returns the value of the pseudo keys for co
+func pseudoValue(co *CfgObj, key string) (val string, found bool, pseudo bool) {
+	switch key {
+	case Q_KEY_TYPE:
+		return co.Type.String(), true, true
+	case Q_KEY_FILEID:
+		return co.FileID, co.FileID != "", true
+	}
+	return "", false, false
+}
+
+func (ov objValuer) value(key string) (string, bool) {
+	if val, found, pseudo := pseudoValue(ov.co, key); pseudo {
+		return val, found
+	}
+	return ov.co.Get(key)
+}
+
+func (n qExists) match(v queryValuer) bool {
+	_, found := v.value(n.key)
+	return found
+}
+
+func (n qCmp) match(v queryValuer) bool {
+	val, found := v.value(n.key)
+	switch n.op {
+	case "=":
+		return found && val == n.val
+	case "!=":
+		return !found || val != n.val
+	case "=~":
+		return found && n.rx.MatchString(val)
+	case "!~":
+		return !found || !n.rx.MatchString(val)
+	}
+	if !found {
+		return false
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+	if err != nil {
+		return false
+	}
+	switch n.op {
+	case "<":
+		return f < n.num
+	case "<=":
+		return f <= n.num
+	case ">":
+		return f > n.num
+	case ">=":
+		return f >= n.num
+	}
+	return false
+}
+
+// SearchQuery returns the objects matching q, in original order if possible.
+// If the map has an index, and the query constrains an indexed key, only the candidates from the index are checked.
+func (cm CfgMap) SearchQuery(q *Query) UUIDs {
+	if idx := indexOf(cm); idx != nil {
+		if ids, ok := idx.candidates(q.root); ok {
+			return cm.SearchQuerySubSet(q, ids)
+		}
+	}
+	return cm.SearchQuerySubSet(q, cm.Keys())
+}
+
+// SearchQuerySubSet returns the objects with the given UUIDs that match q, in the given order
+func (cm CfgMap) SearchQuerySubSet(q *Query, ids UUIDs) UUIDs {
+	var matches UUIDs
+	for _, u := range ids {
+		co, found := cm[u]
+		if found && q.Match(co) {
+			matches = append(matches, u)
+		}
+	}
+	return matches
+}
+
+// SearchQuery works like Search, narrowing down any previous matches, but with a query expression
+func (nc *NagiosCfg) SearchQuery(q *Query) UUIDs {
+	if !nc.matches.Empty() {
+		nc.matches = nc.Config.SearchQuerySubSet(q, nc.matches)
+	} else {
+		nc.matches = nc.Config.SearchQuery(q)
+	}
+	return nc.matches
+}
@@ -0,0 +1,115 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"testing"
+)
+
+var querycfgstr string = `define service{
+	host_name web01
+	service_description HTTP
+	check_command check_http
+	max_check_attempts 3
+}
+define service{
+	host_name web02
+	service_description PING
+	check_command check_ping!100.0,20%!500.0,60%
+	max_check_attempts 10
+	notes has notes
+}
+define service{
+	host_name db01
+	service_description MySQL (primary)
+	check_command check_mysql
+}
+define host{
+	host_name web01
+	address 10.0.0.1
+}
+`
+
+func TestParseQuery(t *testing.T) {
+	nc := loadDiffCfg(t, querycfgstr)
+	tests := []struct {
+		q   string
+		exp []string // host_name of matches, in order
+	}{
+		{`type=service AND host_name=~"^web"`, []string{"web01", "web02"}},
+		{`type=service AND host_name=~^web AND NOT check_command=~ping`, []string{"web01"}},
+		{`host_name=web01`, []string{"web01", "web01"}},
+		{`type = host OR EXISTS notes`, []string{"web02", "web01"}},
+		{`max_check_attempts > 3`, []string{"web02"}},
+		{`max_check_attempts <= 3.0`, []string{"web01"}},
+		{`max_check_attempts != 3 and type=service`, []string{"web02", "db01"}},
+		{`notes !~ notes`, []string{"web01", "db01", "web01"}},
+		{`service_description="MySQL (primary)"`, []string{"db01"}},
+		{`not (type=service and (host_name=web01 or host_name=db01))`, []string{"web02", "web01"}},
+		{`check_command=~"^check_ping!\\d"`, []string{"web02"}},
+	}
+	for _, test := range tests {
+		q, err := ParseQuery(test.q)
+		if err != nil {
+			t.Errorf("%q: %s", test.q, err)
+			continue
+		}
+		var got []string
+		for _, u := range nc.Config.SearchQuery(q) {
+			got = append(got, nc.Config[u].Props["host_name"])
+		}
+		if len(got) != len(test.exp) {
+			t.Errorf("%q: expected %v, got %v", test.q, test.exp, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.exp[i] {
+				t.Errorf("%q: expected %v, got %v", test.q, test.exp, got)
+				break
+			}
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	bad := []string{
+		``,
+		`type=service AND`,
+		`(type=service`,
+		`no_such_key=1`,
+		`host_name`,
+		`host_name=~"(web"`,
+		`max_check_attempts > many`,
+		`host_name="web01`,
+		`type=service)`,
+		`EXISTS`,
+	}
+	for _, s := range bad {
+		if _, err := ParseQuery(s); err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+}
+
+func TestNagiosCfgSearchQuery(t *testing.T) {
+	nc := loadDiffCfg(t, querycfgstr)
+	nc.FilterType(T_SERVICE)
+	m := nc.SearchQuery(MustParseQuery(`host_name=web01`))
+	if len(m) != 1 || nc.Config[m[0]].Type != T_SERVICE {
+		t.Errorf("Expected search to narrow previous matches, got %v", m)
+	}
+}
@@ -0,0 +1,206 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+Template resolution, giving the effective properties of an object the way Nagios sees them.
+The rules followed are those of Nagios object inheritance:
+- values set on the object itself always win
+- templates are found by their "name" among objects of the same type, and are given as a comma separated list in
+  "use". The first template listed wins, including what it inherits itself, over the next one.
+- "name", "use" and "register" are never inherited
+- a value of "null" stops inheritance, the key is then not set at all
+- a value starting with "+" is appended to the inherited value, separated by a comma (additive inheritance)
+Unknown templates and loops in the "use" chain are ignored, as resolving as much as possible is more useful here
+than failing.
+*/
+
+import (
+	"strings"
+)
+
+const (
+	TPL_NULL     string = "null" // value that cancels inheritance
+	TPL_ADDITIVE string = "+"    // prefix for values appended to the inherited value
+)
+
+// ResolvedProp is an effective property value, and the object it came from
+type ResolvedProp struct {
+	Value string
+	From  *CfgObj // the object itself, or the template the value was inherited from
+}
+
+// ResolvedMatch is a result from SearchResolved
+type ResolvedMatch struct {
+	UUID      UUID
+	Obj       *CfgObj
+	Origins   map[string]*CfgObj // the keys the query looked at that were set, and where their value came from
+	Inherited bool               // true if any of the keys in Origins came from a template
+}
+
+// templates finds templates by type and name
+type templates map[CfgType]map[string]*CfgObj
+
+func notInherited(key string) bool {
+	return key == "name" || key == "use" || key == "register"
+}
+
+// templateIndex returns all objects in cm that have a name, by type. If more than one object of the same type
+// has the same name, the first one in original order is used.
+func (cm CfgMap) templateIndex() templates {
+	tpls := make(templates)
+	for _, u := range cm.Keys() {
+		co := cm[u]
+		name, found := co.Get("name")
+		if !found {
+			continue
+		}
+		if tpls[co.Type] == nil {
+			tpls[co.Type] = make(map[string]*CfgObj)
+		}
+		if _, found := tpls[co.Type][name]; !found {
+			tpls[co.Type][name] = co
+		}
+	}
+	return tpls
+}
+
+// resolve returns the effective properties of co. visited guards against loops in the use chain.
+func (tpls templates) resolve(co *CfgObj, visited map[*CfgObj]bool) map[string]ResolvedProp {
+	visited[co] = true
+	defer delete(visited, co) // the same template may be used via more than one path, just not in a loop
+
+	// inherited values, the first template listed winning
+	inherited := make(map[string]ResolvedProp)
+	if use, found := co.Get("use"); found {
+		for _, name := range strings.Split(use, SEP_LST) {
+			tpl, found := tpls[co.Type][strings.TrimSpace(name)]
+			if !found || visited[tpl] {
+				continue
+			}
+			for k, rp := range tpls.resolve(tpl, visited) {
+				if _, found := inherited[k]; !found && !notInherited(k) {
+					inherited[k] = rp
+				}
+			}
+		}
+	}
+
+	props := make(map[string]ResolvedProp, len(co.Props)+len(inherited))
+	for k, rp := range inherited {
+		props[k] = rp
+	}
+	for k, v := range co.Props {
+		switch {
+		case v == TPL_NULL:
+			delete(props, k)
+		case strings.HasPrefix(v, TPL_ADDITIVE):
+			v = strings.TrimPrefix(v, TPL_ADDITIVE)
+			if rp, found := inherited[k]; found {
+				v = rp.Value + SEP_LST + v
+			}
+			props[k] = ResolvedProp{Value: v, From: co}
+		default:
+			props[k] = ResolvedProp{Value: v, From: co}
+		}
+	}
+	return props
+}
+
+// Resolve returns the effective properties of co, with templates looked up in cm
+func (cm CfgMap) Resolve(co *CfgObj) map[string]ResolvedProp {
+	return cm.templateIndex().resolve(co, make(map[*CfgObj]bool))
+}
+
+// ResolvedProps returns the effective properties of co as plain values
+func (cm CfgMap) ResolvedProps(co *CfgObj) map[string]string {
+	rps := cm.Resolve(co)
+	props := make(map[string]string, len(rps))
+	for k, rp := range rps {
+		props[k] = rp.Value
+	}
+	return props
+}
+
+// resolvedValuer gives the effective values of an object to a query, and notes where the ones looked at came from
+type resolvedValuer struct {
+	co      *CfgObj
+	props   map[string]ResolvedProp
+	origins map[string]*CfgObj
+}
+
+func (rv *resolvedValuer) value(key string) (string, bool) {
+	if val, found, pseudo := pseudoValue(rv.co, key); pseudo {
+		return val, found
+	}
+	rp, found := rv.props[key]
+	if !found {
+		return "", false
+	}
+	rv.origins[key] = rp.From
+	return rp.Value, true
+}
+
+// SearchResolvedSubSet is like SearchResolved, but only looks at the objects with the given UUIDs
+func (cm CfgMap) SearchResolvedSubSet(q *Query, ids UUIDs) []*ResolvedMatch {
+	tpls := cm.templateIndex()
+	var matches []*ResolvedMatch
+	for _, u := range ids {
+		co, found := cm[u]
+		if !found {
+			continue
+		}
+		rv := &resolvedValuer{
+			co:      co,
+			props:   tpls.resolve(co, make(map[*CfgObj]bool)),
+			origins: make(map[string]*CfgObj),
+		}
+		if !q.root.match(rv) {
+			continue
+		}
+		m := &ResolvedMatch{UUID: u, Obj: co, Origins: rv.origins}
+		for _, from := range rv.origins {
+			if from != co {
+				m.Inherited = true
+			}
+		}
+		matches = append(matches, m)
+	}
+	return matches
+}
+
+// SearchResolved returns the objects where q matches the effective, template resolved, properties,
+// in original order if possible
+func (cm CfgMap) SearchResolved(q *Query) []*ResolvedMatch {
+	return cm.SearchResolvedSubSet(q, cm.Keys())
+}
+
+// SearchResolved works like SearchQuery, narrowing down any previous matches, but against the template
+// resolved properties. The matches are returned with details on where the values came from.
+func (nc *NagiosCfg) SearchResolved(q *Query) []*ResolvedMatch {
+	var res []*ResolvedMatch
+	if !nc.matches.Empty() {
+		res = nc.Config.SearchResolvedSubSet(q, nc.matches)
+	} else {
+		res = nc.Config.SearchResolved(q)
+	}
+	nc.matches = make(UUIDs, len(res))
+	for i := range res {
+		nc.matches[i] = res[i].UUID
+	}
+	return res
+}
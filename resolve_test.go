@@ -0,0 +1,132 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"testing"
+)
+
+var resolvecfgstr string = `define service{
+	name generic-service
+	notification_interval 0
+	max_check_attempts 3
+	contact_groups admins
+	register 0
+}
+define service{
+	name web-service
+	use generic-service
+	max_check_attempts 5
+	notes web
+	register 0
+}
+define service{
+	name loop-a
+	use loop-b
+	register 0
+}
+define service{
+	name loop-b
+	use loop-a
+	register 0
+}
+define service{
+	host_name web01
+	service_description HTTP
+	use web-service,generic-service
+	contact_groups +webadmins
+}
+define service{
+	host_name web02
+	service_description HTTP
+	use web-service
+	notification_interval 60
+	notes null
+}
+define service{
+	host_name db01
+	service_description MySQL
+	use loop-a,missing
+}
+`
+
+func TestResolve(t *testing.T) {
+	nc := loadDiffCfg(t, resolvecfgstr)
+	web01, _ := nc.Config.GetByIdentity(T_SERVICE, "web01;HTTP")
+	web02, _ := nc.Config.GetByIdentity(T_SERVICE, "web02;HTTP")
+	generic, _ := nc.Config.GetByIdentity(T_SERVICE, "name=generic-service")
+
+	rp := nc.Config.Resolve(web01)
+	exp := map[string]string{
+		"host_name":             "web01",
+		"service_description":   "HTTP",
+		"use":                   "web-service,generic-service",
+		"contact_groups":        "admins,webadmins",
+		"notification_interval": "0",
+		"max_check_attempts":    "5",
+		"notes":                 "web",
+	}
+	if len(rp) != len(exp) {
+		t.Errorf("Expected %d props, got %d: %v", len(exp), len(rp), nc.Config.ResolvedProps(web01))
+	}
+	for k, v := range exp {
+		if rp[k].Value != v {
+			t.Errorf("Expected %s = %q, got %q", k, v, rp[k].Value)
+		}
+	}
+	if rp["notification_interval"].From != generic || rp["contact_groups"].From != web01 {
+		t.Error("Wrong origin of resolved values")
+	}
+
+	props := nc.Config.ResolvedProps(web02)
+	if _, found := props["notes"]; found {
+		t.Error("null should stop inheritance")
+	}
+	if props["notification_interval"] != "60" {
+		t.Errorf("Own value should win, got %q", props["notification_interval"])
+	}
+
+	// loops and unknown templates are ignored
+	db01, _ := nc.Config.GetByIdentity(T_SERVICE, "db01;MySQL")
+	if len(nc.Config.Resolve(db01)) != 3 {
+		t.Errorf("Unexpected props for db01: %v", nc.Config.ResolvedProps(db01))
+	}
+}
+
+func TestSearchResolved(t *testing.T) {
+	nc := loadDiffCfg(t, resolvecfgstr)
+	q := MustParseQuery(`EXISTS host_name AND notification_interval=0`)
+
+	if len(nc.Config.SearchQuery(q)) != 0 {
+		t.Error("Plain search should not see inherited values")
+	}
+	res := nc.SearchResolved(q)
+	if len(res) != 1 || res[0].Obj.Props["host_name"] != "web01" {
+		t.Fatalf("Expected web01 only, got %d matches", len(res))
+	}
+	if !res[0].Inherited || res[0].Origins["host_name"] != res[0].Obj || res[0].Origins["notification_interval"].Props["name"] != "generic-service" {
+		t.Errorf("Wrong origins: %+v", res[0].Origins)
+	}
+	if len(nc.GetMatches()) != 1 {
+		t.Errorf("Expected matches to be updated, got %d", len(nc.GetMatches()))
+	}
+
+	res = nc.Config.SearchResolved(MustParseQuery(`host_name=web02 AND notification_interval=60`))
+	if len(res) != 1 || res[0].Inherited {
+		t.Error("Expected a match on own values only")
+	}
+}
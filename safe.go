@@ -0,0 +1,242 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+Concurrency safe access to a config, for long running services answering queries while applying edits.
+NagiosCfg itself is not safe for concurrent use: CfgMap is a plain map, and the matches are shared by all
+callers. SafeCfg wraps a NagiosCfg with a RWMutex, and hands out sessions, each with its own matches, so that
+one caller's search does not narrow down another's.
+
+Searches take the read lock, and any number of them can run at the same time. Edits take the write lock.
+Objects handed out by sessions are copies, so they stay consistent after the lock is released. For anything
+not covered, Read and Write run a function with the lock held, on a view of the config with the session's
+matches.
+*/
+
+import (
+	"io"
+	"sync"
+)
+
+var uuidorderMutex sync.RWMutex
+
+// addOrder records UUIDs in the order objects were read. Safe for concurrent use.
+func addOrder(u ...UUID) {
+	uuidorderMutex.Lock()
+	uuidorder = append(uuidorder, u...)
+	uuidorderMutex.Unlock()
+}
+
+// readOrder returns the order objects were read in. As the slice is only ever appended to, what's returned
+// can be used after the lock is released, it just won't see objects read later.
+func readOrder() UUIDs {
+	uuidorderMutex.RLock()
+	defer uuidorderMutex.RUnlock()
+	return uuidorder
+}
+
+// SafeCfg is a NagiosCfg for concurrent use, see the top of this file
+type SafeCfg struct {
+	mu sync.RWMutex
+	nc *NagiosCfg
+}
+
+// Session is one caller's view of a SafeCfg, with its own matches. A session itself is not meant to be
+// shared between goroutines.
+type Session struct {
+	ID      UUID
+	sc      *SafeCfg
+	matches UUIDs
+}
+
+// NewSafeCfg wraps nc. From now on, nc should only be used through the wrapper.
+func NewSafeCfg(nc *NagiosCfg) *SafeCfg {
+	sc := &SafeCfg{nc: nc}
+	sc.share()
+	return sc
+}
+
+// share prepares the caches and index of the wrapped config for readers, who share them through their views,
+// but must not make or rebuild them. Called with the write lock held, or before any readers exist.
+func (sc *SafeCfg) share() {
+	sc.nc.relCache()
+	if idx := sc.nc.Index(); idx != nil { // moved along if the config was replaced
+		idx.shared = false
+		idx.fresh()
+		idx.shared = true
+	}
+}
+
+// NewSession returns a new session, with no matches
+func (sc *SafeCfg) NewSession() *Session {
+	return &Session{ID: NewUUIDv1(), sc: sc}
+}
+
+// view returns a NagiosCfg sharing everything with the wrapped one, except the matches
+func (sc *SafeCfg) view(matches UUIDs) *NagiosCfg {
+	v := *sc.nc
+	v.matches = matches
+	return &v
+}
+
+// Read runs f with the read lock held. f must not change anything, except the matches of the view it's given.
+func (sc *SafeCfg) Read(f func(nc *NagiosCfg)) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	f(sc.view(nil))
+}
+
+// Write runs f with the write lock held, on the wrapped config itself
+func (sc *SafeCfg) Write(f func(nc *NagiosCfg)) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.nc.index != nil {
+		sc.nc.index.shared = false // no readers while we hold the lock
+	}
+	f(sc.nc)
+	sc.share()
+}
+
+// Len returns the number of objects in the config
+func (sc *SafeCfg) Len() int {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.nc.Len()
+}
+
+// Snapshot returns a deep copy of the config as it is now, for iterating without holding any lock
+func (sc *SafeCfg) Snapshot() CfgMap {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.nc.Config.Copy()
+}
+
+// Get returns a copy of the object with the given UUID
+func (sc *SafeCfg) Get(u UUID) (*CfgObj, bool) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	co, found := sc.nc.Config[u]
+	if !found {
+		return nil, false
+	}
+	return co.Copy(), true
+}
+
+// read runs f on a view with the session's matches, with the read lock held, and keeps the resulting matches
+func (s *Session) read(f func(nc *NagiosCfg)) {
+	s.sc.mu.RLock()
+	defer s.sc.mu.RUnlock()
+	v := s.sc.view(s.matches)
+	f(v)
+	s.matches = v.matches
+}
+
+// write is like read, with the write lock held
+func (s *Session) write(f func(nc *NagiosCfg)) {
+	s.sc.Write(func(nc *NagiosCfg) {
+		v := s.sc.view(s.matches)
+		f(v)
+		s.matches = v.matches
+	})
+}
+
+// Read runs f with the read lock held, on a view with the session's matches. See SafeCfg.Read.
+func (s *Session) Read(f func(nc *NagiosCfg)) {
+	s.read(f)
+}
+
+// Write runs f with the write lock held, on a view with the session's matches
+func (s *Session) Write(f func(nc *NagiosCfg)) {
+	s.write(f)
+}
+
+// Matches returns the current matches of the session
+func (s *Session) Matches() UUIDs {
+	return s.matches
+}
+
+// ClearMatches clears the matches of the session
+func (s *Session) ClearMatches() {
+	s.matches = nil
+}
+
+// FilterType works like NagiosCfg.FilterType, on the session's matches
+func (s *Session) FilterType(ts ...CfgType) (res UUIDs) {
+	s.read(func(nc *NagiosCfg) { res = nc.FilterType(ts...) })
+	return
+}
+
+// Search works like NagiosCfg.Search, on the session's matches
+func (s *Session) Search(q *CfgQuery) (res UUIDs) {
+	s.read(func(nc *NagiosCfg) { res = nc.Search(q) })
+	return
+}
+
+// SearchQuery works like NagiosCfg.SearchQuery, on the session's matches
+func (s *Session) SearchQuery(q *Query) (res UUIDs) {
+	s.read(func(nc *NagiosCfg) { res = nc.SearchQuery(q) })
+	return
+}
+
+// Objs returns copies of the matched objects, in order
+func (s *Session) Objs() CfgObjs {
+	var cos CfgObjs
+	s.read(func(nc *NagiosCfg) {
+		cos = nc.Config.Objs(nc.matches)
+		for i := range cos {
+			cos[i] = cos[i].Copy()
+		}
+	})
+	return cos
+}
+
+// PrintMatches works like NagiosCfg.PrintMatches, on the session's matches
+func (s *Session) PrintMatches(w io.Writer, sorted bool) (err error) {
+	s.read(func(nc *NagiosCfg) { err = nc.PrintMatches(w, sorted) })
+	return
+}
+
+// SetKeys works like NagiosCfg.SetKeys, on the session's matches
+func (s *Session) SetKeys(keys, values []string) (n int) {
+	s.write(func(nc *NagiosCfg) { n = nc.SetKeys(keys, values) })
+	return
+}
+
+// DelKeys works like NagiosCfg.DelKeys, on the session's matches
+func (s *Session) DelKeys(keys []string) (n int) {
+	s.write(func(nc *NagiosCfg) { n = nc.DelKeys(keys) })
+	return
+}
+
+// DeleteMatches works like NagiosCfg.DeleteMatches, on the session's matches
+func (s *Session) DeleteMatches() (cm CfgMap) {
+	s.write(func(nc *NagiosCfg) { cm = nc.DeleteMatches() })
+	return
+}
+
+// DeleteMatchesMode works like NagiosCfg.DeleteMatchesMode, on the session's matches
+func (s *Session) DeleteMatchesMode(mode DelMode) (cm CfgMap, err error) {
+	s.write(func(nc *NagiosCfg) { cm, err = nc.DeleteMatchesMode(mode) })
+	return
+}
+
+// ApplyPatch works like NagiosCfg.ApplyPatch
+func (s *Session) ApplyPatch(p *Patch) (inv *Patch, err error) {
+	s.write(func(nc *NagiosCfg) { inv, err = nc.ApplyPatch(p) })
+	return
+}
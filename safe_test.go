@@ -0,0 +1,123 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSafeCfgSessions(t *testing.T) {
+	sc := NewSafeCfg(loadDiffCfg(t, querycfgstr))
+	s1 := sc.NewSession()
+	s2 := sc.NewSession()
+
+	s1.FilterType(T_SERVICE)
+	s2.FilterType(T_HOST)
+	if len(s1.SearchQuery(MustParseQuery(`host_name=~^web`))) != 2 || len(s2.Matches()) != 1 {
+		t.Fatal("Sessions should have separate matches")
+	}
+	if n := s2.SetKeys([]string{"notes"}, []string{"session 2"}); n != 1 {
+		t.Errorf("Expected 1 change, got %d", n)
+	}
+	cos := s2.Objs()
+	cos[0].Set("notes", "changed copy")
+	co, _ := sc.Get(cos[0].UUID)
+	if co.Props["notes"] != "session 2" {
+		t.Error("Objects from sessions should be copies")
+	}
+	if deleted := s1.DeleteMatches(); len(deleted) != 2 || sc.Len() != 2 {
+		t.Errorf("Expected 2 deleted and 2 left, got %d and %d", len(deleted), sc.Len())
+	}
+	if len(s2.Matches()) != 1 {
+		t.Error("Delete in one session should not touch the matches of another")
+	}
+}
+
+func TestSafeCfgConcurrent(t *testing.T) {
+	nc := loadDiffCfg(t, querycfgstr)
+	sc := NewSafeCfg(nc)
+	sc.Write(func(nc *NagiosCfg) { nc.EnableIndex() })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s := sc.NewSession()
+			for j := 0; j < 50; j++ {
+				s.ClearMatches()
+				if len(s.SearchQuery(MustParseQuery(`type=host`))) == 0 {
+					t.Error("Host disappeared")
+					return
+				}
+				var buf bytes.Buffer
+				if err := s.PrintMatches(&buf, true); err != nil || !strings.Contains(buf.String(), "define host{") {
+					t.Errorf("Printing matches failed: %v", err)
+					return
+				}
+				for range sc.Snapshot() {
+				}
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				sc.Write(func(nc *NagiosCfg) {
+					co := NewCfgObjWithUUID(T_COMMAND)
+					co.Add("command_name", fmt.Sprintf("cmd_%d_%d", i, j))
+					nc.Config.AddByUUID(co.UUID, co)
+					addOrder(co.UUID)
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	if sc.Len() != 4+8*50 {
+		t.Errorf("Expected %d objects, got %d", 4+8*50, sc.Len())
+	}
+}
+
+func TestSafeCfgSharedIndex(t *testing.T) {
+	nc := loadDiffCfg(t, querycfgstr)
+	nc.EnableIndex()
+	sc := NewSafeCfg(nc)
+	idx := nc.Index()
+
+	// an object added behind the index's back must not make readers rebuild it
+	co := NewCfgObjWithUUID(T_HOST)
+	co.Add("host_name", "sneaky")
+	nc.Config[co.UUID] = co
+	addOrder(co.UUID)
+	count := idx.count
+	sc.Read(func(nc *NagiosCfg) {
+		if len(nc.Config.FilterType(T_HOST)) != 2 {
+			t.Errorf("Expected the reader to scan for 2 hosts, got %d", len(nc.Config.FilterType(T_HOST)))
+		}
+	})
+	if idx.count != count {
+		t.Error("Index rebuilt under the read lock")
+	}
+
+	sc.Write(func(nc *NagiosCfg) {})
+	if idx.count != len(nc.Config) || !idx.shared {
+		t.Errorf("Expected the index to be rebuilt and shared after a write, got %d of %d objects", idx.count, len(nc.Config))
+	}
+}
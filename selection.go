@@ -0,0 +1,255 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+Named selections, for when narrowing down the current matches with Search is not enough.
+The current matches can be saved under a name, and named selections combined with Union, Intersect, Difference
+and Complement into new ones. The name SEL_MATCHES refers to the current matches, so the result of a search can
+be used directly, and results can be made the current matches, e.g:
+
+	nc.Search(qa)
+	nc.SaveSelection("a")
+	nc.ClearMatches()
+	nc.Search(qb)
+	nc.Union(SEL_MATCHES, "a", SEL_MATCHES)
+
+The bulk operations are available for any named selection through Select, leaving the current matches alone.
+Objects deleted from the config are left out of selections when they are read.
+*/
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+const SEL_MATCHES string = "." // name for the current matches
+
+// Selection is a handle for a named selection, see NagiosCfg.Select
+type Selection struct {
+	nc   *NagiosCfg
+	name string
+}
+
+// Union returns the UUIDs in u or u2, in the order of u followed by the ones only in u2
+func (u UUIDs) Union(u2 UUIDs) UUIDs {
+	seen := make(uuidSet, len(u)+len(u2))
+	res := make(UUIDs, 0, len(u)+len(u2))
+	for _, l := range []UUIDs{u, u2} {
+		for _, v := range l {
+			if _, found := seen[v]; !found {
+				seen[v] = struct{}{}
+				res = append(res, v)
+			}
+		}
+	}
+	return res
+}
+
+// Intersect returns the UUIDs in both u and u2, in the order of u
+func (u UUIDs) Intersect(u2 UUIDs) UUIDs {
+	in2 := make(uuidSet, len(u2))
+	for _, v := range u2 {
+		in2[v] = struct{}{}
+	}
+	res := make(UUIDs, 0)
+	for _, v := range u {
+		if _, found := in2[v]; found {
+			res = append(res, v)
+			delete(in2, v) // no duplicates
+		}
+	}
+	return res
+}
+
+// Difference returns the UUIDs in u that are not in u2, in the order of u
+func (u UUIDs) Difference(u2 UUIDs) UUIDs {
+	in2 := make(uuidSet, len(u2))
+	for _, v := range u2 {
+		in2[v] = struct{}{}
+	}
+	res := make(UUIDs, 0)
+	for _, v := range u {
+		if _, found := in2[v]; !found {
+			res = append(res, v)
+			in2[v] = struct{}{} // no duplicates
+		}
+	}
+	return res
+}
+
+// Selection returns the named selection, without objects that have been deleted since it was saved
+func (nc *NagiosCfg) Selection(name string) (UUIDs, error) {
+	var ids UUIDs
+	if name == SEL_MATCHES {
+		ids = nc.matches
+	} else {
+		var found bool
+		ids, found = nc.selections[name]
+		if !found {
+			return nil, fmt.Errorf("No such selection: %q %s", name, dbgStr(true))
+		}
+	}
+	res := make(UUIDs, 0, len(ids))
+	for _, u := range ids {
+		if _, found := nc.Config[u]; found {
+			res = append(res, u)
+		}
+	}
+	return res, nil
+}
+
+// SetSelection stores ids as the named selection, replacing any existing one
+func (nc *NagiosCfg) SetSelection(name string, ids UUIDs) {
+	if name == SEL_MATCHES {
+		nc.matches = ids
+		return
+	}
+	if nc.selections == nil {
+		nc.selections = make(map[string]UUIDs)
+	}
+	nc.selections[name] = append(UUIDs(nil), ids...)
+}
+
+// SaveSelection stores the current matches under the given name
+func (nc *NagiosCfg) SaveSelection(name string) {
+	nc.SetSelection(name, nc.matches)
+}
+
+// DropSelection removes the named selection
+func (nc *NagiosCfg) DropSelection(name string) {
+	if name == SEL_MATCHES {
+		nc.ClearMatches()
+		return
+	}
+	delete(nc.selections, name)
+}
+
+// Selections returns the names of all saved selections, sorted
+func (nc *NagiosCfg) Selections() []string {
+	names := make([]string, 0, len(nc.selections))
+	for name := range nc.selections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// combine applies op to the named selections from left to right, and stores the result as dst
+func (nc *NagiosCfg) combine(op func(UUIDs, UUIDs) UUIDs, dst string, srcs ...string) (UUIDs, error) {
+	if len(srcs) == 0 {
+		return nil, fmt.Errorf("No selections given %s", dbgStr(true))
+	}
+	res, err := nc.Selection(srcs[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range srcs[1:] {
+		ids, err := nc.Selection(name)
+		if err != nil {
+			return nil, err
+		}
+		res = op(res, ids)
+	}
+	nc.SetSelection(dst, res)
+	return res, nil
+}
+
+// Union stores the objects in any of the named selections as dst
+func (nc *NagiosCfg) Union(dst string, srcs ...string) (UUIDs, error) {
+	return nc.combine(UUIDs.Union, dst, srcs...)
+}
+
+// Intersect stores the objects in all of the named selections as dst
+func (nc *NagiosCfg) Intersect(dst string, srcs ...string) (UUIDs, error) {
+	return nc.combine(UUIDs.Intersect, dst, srcs...)
+}
+
+// Difference stores the objects in the first named selection, but none of the others, as dst
+func (nc *NagiosCfg) Difference(dst string, srcs ...string) (UUIDs, error) {
+	return nc.combine(UUIDs.Difference, dst, srcs...)
+}
+
+// Complement stores all objects in the config that are not in the named selection as dst, in original order
+func (nc *NagiosCfg) Complement(dst, src string) (UUIDs, error) {
+	ids, err := nc.Selection(src)
+	if err != nil {
+		return nil, err
+	}
+	res := nc.Config.Keys().Difference(ids)
+	nc.SetSelection(dst, res)
+	return res, nil
+}
+
+// Select returns a handle for running bulk operations on the named selection
+func (nc *NagiosCfg) Select(name string) *Selection {
+	return &Selection{nc: nc, name: name}
+}
+
+// IDs returns the objects in the selection
+func (s *Selection) IDs() (UUIDs, error) {
+	return s.nc.Selection(s.name)
+}
+
+// with runs f with the selection as the current matches, restoring them afterwards. f is not run for empty
+// selections, as the bulk operations treat no matches as all objects.
+func (s *Selection) with(f func()) error {
+	ids, err := s.IDs()
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	saved := s.nc.matches
+	s.nc.matches = ids
+	f()
+	s.nc.matches = saved
+	return nil
+}
+
+// SetKeys sets the keys on all objects in the selection, see NagiosCfg.SetKeys
+func (s *Selection) SetKeys(keys, values []string) (n int, err error) {
+	err = s.with(func() { n = s.nc.SetKeys(keys, values) })
+	return
+}
+
+// DelKeys deletes the keys from all objects in the selection, see NagiosCfg.DelKeys
+func (s *Selection) DelKeys(keys []string) (n int, err error) {
+	err = s.with(func() { n = s.nc.DelKeys(keys) })
+	return
+}
+
+// Delete deletes all objects in the selection from the config, and returns them
+func (s *Selection) Delete() (cm CfgMap, err error) {
+	err = s.with(func() { cm = s.nc.DeleteMatches() })
+	if err == nil && s.name == SEL_MATCHES {
+		s.nc.ClearMatches() // as DeleteMatches would, instead of restoring them
+	}
+	return
+}
+
+// Print prints all objects in the selection, see NagiosCfg.PrintMatches
+func (s *Selection) Print(w io.Writer, sorted bool) error {
+	var perr error
+	err := s.with(func() { perr = s.nc.PrintMatches(w, sorted) })
+	if err != nil {
+		return err
+	}
+	return perr
+}
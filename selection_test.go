@@ -0,0 +1,106 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSelections(t *testing.T) {
+	nc := loadDiffCfg(t, querycfgstr)
+	search := func(name, q string) {
+		nc.ClearMatches()
+		nc.SearchQuery(MustParseQuery(q))
+		nc.SaveSelection(name)
+	}
+	search("web", `host_name=~^web`)
+	search("svc", `type=service`)
+	search("ping", `check_command=~ping`)
+	nc.ClearMatches()
+
+	tests := []struct {
+		f   func() (UUIDs, error)
+		exp []string
+	}{
+		{func() (UUIDs, error) { return nc.Union("u", "ping", "svc") }, []string{"web02", "web01", "db01"}},
+		{func() (UUIDs, error) { return nc.Intersect("i", "svc", "web") }, []string{"web01", "web02"}},
+		{func() (UUIDs, error) { return nc.Difference("d", "svc", "web", "ping") }, []string{"db01"}},
+		{func() (UUIDs, error) { return nc.Complement("c", "svc") }, []string{"web01"}},
+		{func() (UUIDs, error) { return nc.Difference(SEL_MATCHES, "web", "ping") }, []string{"web01", "web01"}},
+	}
+	for i, test := range tests {
+		ids, err := test.f()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hostNames(nc.Config, ids); !sameStrings(got, test.exp) {
+			t.Errorf("#%d: expected %v, got %v", i, test.exp, got)
+		}
+	}
+	if len(nc.GetMatches()) != 2 {
+		t.Error("Expected result stored as current matches")
+	}
+	if _, err := nc.Union("x", "svc", "nonexistent"); err == nil {
+		t.Error("Expected error for unknown selection")
+	}
+	if names := nc.Selections(); strings.Join(names, ",") != "c,d,i,ping,svc,u,web" {
+		t.Errorf("Unexpected selection names: %v", names)
+	}
+}
+
+func TestSelectionBulkOps(t *testing.T) {
+	nc := loadDiffCfg(t, querycfgstr)
+	nc.SearchQuery(MustParseQuery(`type=host`))
+	matches := nc.GetMatches()
+	nc.SetSelection("web", nc.Config.SearchQuery(MustParseQuery(`type=service AND host_name=~^web`)))
+
+	n, err := nc.Select("web").SetKeys([]string{"notes"}, []string{"bulk"})
+	if err != nil || n != 2 {
+		t.Fatalf("SetKeys: %d, %v", n, err)
+	}
+	var buf bytes.Buffer
+	if err := nc.Select("web").Print(&buf, true); err != nil || strings.Count(buf.String(), "bulk") != 2 {
+		t.Errorf("Print: %v\n%s", err, buf.String())
+	}
+	if _, err := nc.Select("web").DelKeys([]string{"notes"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(nc.Config.SearchQuery(MustParseQuery(`EXISTS notes`))) != 0 {
+		t.Error("DelKeys on selection failed")
+	}
+	if len(nc.GetMatches()) != len(matches) || !nc.GetMatches()[0].Equals(matches[0]) {
+		t.Error("Current matches should be left alone")
+	}
+
+	nc.SetSelection("none", nil)
+	if n, _ := nc.Select("none").SetKeys([]string{"notes"}, []string{"x"}); n != 0 {
+		t.Error("Empty selection should not touch anything")
+	}
+
+	deleted, err := nc.Select("web").Delete()
+	if err != nil || len(deleted) != 2 || nc.Len() != 2 {
+		t.Errorf("Delete: %d deleted, %d left, %v", len(deleted), nc.Len(), err)
+	}
+	if ids, _ := nc.Selection("web"); len(ids) != 0 {
+		t.Error("Deleted objects should not be in selections")
+	}
+	if _, err := nc.Select("nonexistent").Delete(); err == nil {
+		t.Error("Expected error for unknown selection")
+	}
+}
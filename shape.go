@@ -0,0 +1,246 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+Shaping search results: sorting, grouping and distinct values, and printing them as tables.
+Keys can be any property, or the pseudo keys "type" and "fileid" from the query language (see query.go).
+Sort keys prefixed with "-" sort in descending order.
+
+Values are compared in natural order: values that are both numbers are compared as such, so "0.25" comes before
+"0.5" and "-5" before "3". Otherwise runs of digits are compared as numbers, so "web2" comes before "web10",
+"10.0.0.9" before "10.0.0.10" and "5" before "40". Objects missing a sort key come last.
+*/
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const SORT_DESC string = "-" // prefix for sort keys in descending order
+
+// Group is a set of objects with the same value for the key grouped by
+type Group struct {
+	Value string
+	Objs  CfgObjs
+}
+
+// Objs returns the objects with the given UUIDs, in the same order. UUIDs not in the map are skipped.
+func (cm CfgMap) Objs(ids UUIDs) CfgObjs {
+	cos := make(CfgObjs, 0, len(ids))
+	for _, u := range ids {
+		if co, found := cm[u]; found {
+			cos = append(cos, co)
+		}
+	}
+	return cos
+}
+
+// sortValue returns the value of key for co, including the pseudo keys
+func sortValue(co *CfgObj, key string) (string, bool) {
+	return objValuer{co}.value(key)
+}
+
+// parseNumber returns s as a number, if it's written as one. Words like "inf" and "nan" are not numbers here.
+func parseNumber(s string) (float64, bool) {
+	if s == "" || strings.IndexByte("0123456789+-.", s[0]) < 0 {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+// naturalLess compares numbers as numbers, and other strings with runs of digits compared as numbers
+func naturalLess(a, b string) bool {
+	if fa, ok := parseNumber(a); ok {
+		if fb, ok := parseNumber(b); ok && fa != fb {
+			return fa < fb
+		}
+	}
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			si, sj := i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			na := strings.TrimLeft(a[si:i], "0")
+			nb := strings.TrimLeft(b[sj:j], "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
+		}
+		if a[i] != b[j] {
+			return a[i] < b[j]
+		}
+		i++
+		j++
+	}
+	return len(a)-i < len(b)-j
+}
+
+// SortBy sorts the objects by the given keys, in place, and returns them. The sort is stable, so objects
+// equal on all keys keep their order.
+func (cos CfgObjs) SortBy(keys ...string) CfgObjs {
+	sort.SliceStable(cos, func(i, j int) bool {
+		for _, k := range keys {
+			desc := strings.HasPrefix(k, SORT_DESC)
+			k = strings.TrimPrefix(k, SORT_DESC)
+			vi, fi := sortValue(cos[i], k)
+			vj, fj := sortValue(cos[j], k)
+			switch {
+			case fi != fj:
+				return fi // missing values last, no matter the direction
+			case vi == vj:
+				continue
+			case desc:
+				return naturalLess(vj, vi)
+			default:
+				return naturalLess(vi, vj)
+			}
+		}
+		return false
+	})
+	return cos
+}
+
+// Sort returns the given UUIDs sorted by keys, see CfgObjs.SortBy
+func (cm CfgMap) Sort(ids UUIDs, keys ...string) UUIDs {
+	cos := cm.Objs(ids).SortBy(keys...)
+	res := make(UUIDs, len(cos))
+	for i := range cos {
+		res[i] = cos[i].UUID
+	}
+	return res
+}
+
+// SortMatches sorts the current matches by keys, see CfgObjs.SortBy
+func (nc *NagiosCfg) SortMatches(keys ...string) UUIDs {
+	nc.matches = nc.Config.Sort(nc.matches, keys...)
+	return nc.matches
+}
+
+func (cos CfgObjs) group(key string, split bool) []Group {
+	idx := make(map[string]int)
+	var groups []Group
+	add := func(val string, co *CfgObj) {
+		i, found := idx[val]
+		if !found {
+			i = len(groups)
+			idx[val] = i
+			groups = append(groups, Group{Value: val})
+		}
+		groups[i].Objs = append(groups[i].Objs, co)
+	}
+	for _, co := range cos {
+		val, _ := sortValue(co, key)
+		if !split {
+			add(val, co)
+			continue
+		}
+		for _, v := range splitList(val) {
+			add(v, co)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return naturalLess(groups[i].Value, groups[j].Value)
+	})
+	return groups
+}
+
+// GroupBy groups the objects by the value of key, sorted by value. Objects without the key are grouped
+// under "". Objects keep their order within each group.
+func (cos CfgObjs) GroupBy(key string) []Group {
+	return cos.group(key, false)
+}
+
+// GroupByEach is like GroupBy, but splits list values, so that an object with "host_name web01,web02"
+// is in the group for both hosts
+func (cos CfgObjs) GroupByEach(key string) []Group {
+	return cos.group(key, true)
+}
+
+// Distinct returns the distinct values of key, sorted. Objects without the key are not counted.
+func (cos CfgObjs) Distinct(key string) []string {
+	seen := make(map[string]bool)
+	var vals []string
+	for _, co := range cos {
+		if val, found := sortValue(co, key); found && !seen[val] {
+			seen[val] = true
+			vals = append(vals, val)
+		}
+	}
+	sort.SliceStable(vals, func(i, j int) bool {
+		return naturalLess(vals[i], vals[j])
+	})
+	return vals
+}
+
+// Count returns the number of objects in the group
+func (g Group) Count() int {
+	return len(g.Objs)
+}
+
+// newTable returns a tabwriter for tables with columns separated by at least two spaces
+func newTable(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+}
+
+// PrintTable prints the objects as a table, with a column for each key and a header with the key names.
+// Missing values are printed as "-".
+func (cos CfgObjs) PrintTable(w io.Writer, keys ...string) error {
+	tw := newTable(w)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(keys, "\t")))
+	row := make([]string, len(keys))
+	for _, co := range cos {
+		for i, k := range keys {
+			val, found := sortValue(co, k)
+			if !found || val == "" {
+				val = "-"
+			}
+			row[i] = val
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// PrintGroups prints the groups as a table of values and counts, with the given key as header
+func PrintGroups(w io.Writer, key string, groups []Group) error {
+	tw := newTable(w)
+	fmt.Fprintf(tw, "%s\tCOUNT\n", strings.ToUpper(key))
+	for _, g := range groups {
+		val := g.Value
+		if val == "" {
+			val = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%d\n", val, g.Count())
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,131 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"bytes"
+	"testing"
+)
+
+var shapecfgstr string = `define host{
+	host_name web10
+	address 10.0.0.10
+}
+define host{
+	host_name web2
+	address 10.0.0.9
+}
+define host{
+	host_name db1
+	address 10.0.0.9
+}
+define host{
+	host_name nowhere
+}
+define service{
+	host_name web2,web10
+	service_description HTTP
+}
+define service{
+	host_name web2
+	service_description PING
+}
+`
+
+func TestNaturalLess(t *testing.T) {
+	less := [][2]string{
+		{"web2", "web10"},
+		{"10.0.0.9", "10.0.0.10"},
+		{"5", "40"},
+		{"a", "b"},
+		{"web", "web1"},
+		{"007", "8"},
+		{"0.25", "0.5"},
+		{"-5", "3"},
+		{"-10", "-2"},
+		{"1e3", "2000"},
+		{"1", "1.0"},
+		{"inf", "nan"},
+	}
+	for _, p := range less {
+		if !naturalLess(p[0], p[1]) || naturalLess(p[1], p[0]) {
+			t.Errorf("Expected %q < %q", p[0], p[1])
+		}
+	}
+	if naturalLess("web01", "web01") {
+		t.Error("Equal strings are not less")
+	}
+}
+
+func TestSortBy(t *testing.T) {
+	nc := loadDiffCfg(t, shapecfgstr)
+	hosts := nc.Config.Objs(nc.Config.FilterType(T_HOST))
+
+	if got := propValues(hosts.SortBy("address", "host_name"), "host_name"); !sameStrings(got, []string{"db1", "web2", "web10", "nowhere"}) {
+		t.Errorf("Sort by address: got %v", got)
+	}
+	if got := propValues(hosts.SortBy("-address", "-host_name"), "host_name"); !sameStrings(got, []string{"web10", "web2", "db1", "nowhere"}) {
+		t.Errorf("Reverse sort by address: got %v", got)
+	}
+
+	nc.SearchQuery(MustParseQuery(`type=host`))
+	if got := hostNames(nc.Config, nc.SortMatches("host_name")); !sameStrings(got, []string{"db1", "nowhere", "web2", "web10"}) {
+		t.Errorf("SortMatches: got %v", got)
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	nc := loadDiffCfg(t, shapecfgstr)
+	all := nc.Config.Objs(nc.Config.Keys())
+
+	groups := all.GroupBy(Q_KEY_TYPE)
+	if len(groups) != 2 || groups[0].Value != "host" || groups[0].Count() != 4 || groups[1].Count() != 2 {
+		t.Errorf("Unexpected groups by type: %+v", groups)
+	}
+
+	svcs := nc.Config.Objs(nc.Config.FilterType(T_SERVICE))
+	groups = svcs.GroupByEach("host_name")
+	if len(groups) != 2 || groups[0].Value != "web2" || groups[0].Count() != 2 || groups[1].Count() != 1 {
+		t.Errorf("Unexpected services per host: %+v", groups)
+	}
+
+	if got := all.Distinct("address"); !sameStrings(got, []string{"10.0.0.9", "10.0.0.10"}) {
+		t.Errorf("Distinct: got %v", got)
+	}
+
+	var buf bytes.Buffer
+	if err := PrintGroups(&buf, "host_name", groups); err != nil {
+		t.Fatal(err)
+	}
+	exp := "HOST_NAME  COUNT\nweb2       2\nweb10      1\n"
+	if buf.String() != exp {
+		t.Errorf("Expected:\n%s\nGot:\n%s", exp, buf.String())
+	}
+}
+
+func TestPrintTable(t *testing.T) {
+	nc := loadDiffCfg(t, shapecfgstr)
+	hosts := nc.Config.Objs(nc.Config.FilterType(T_HOST)).SortBy("host_name")
+	var buf bytes.Buffer
+	if err := hosts[:2].PrintTable(&buf, "host_name", "address"); err != nil {
+		t.Fatal(err)
+	}
+	exp := "HOST_NAME  ADDRESS\ndb1        10.0.0.9\nnowhere    -\n"
+	if buf.String() != exp {
+		t.Errorf("Expected:\n%s\nGot:\n%s", exp, buf.String())
+	}
+}
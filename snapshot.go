@@ -0,0 +1,163 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+Immutable snapshots, with copy-on-write editing, so that several edited versions of a big config can be kept
+around and compared without deep copying all objects for each of them.
+
+Taking a snapshot freezes all objects in the config, and the snapshot shares them with the config, and with any
+configs derived from the snapshot. A frozen object is never changed again: CfgObj.Set and Del panic with
+ErrFrozen, TrySet and TryDel return it, and Add and AddList return false. Maps holding it don't set its observers
+either, as it can be in several maps at once. Instead, the config makes a private copy the first time an object is changed through
+it, using NagiosCfg.Edit, or the bulk operations (SetKeys, DelKeys, patches, undo/redo), which all do this.
+Code holding on to a *CfgObj from before a snapshot, or taking one straight from NagiosCfg.Config, must get it
+again with Edit to change it.
+
+Since unchanged objects are shared, comparing snapshots only needs to look closer at the objects that differ.
+*/
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrFrozen is returned by CfgObj.TrySet and TryDel for objects shared with a snapshot
+var ErrFrozen = errors.New("object is frozen, use NagiosCfg.Edit")
+
+// Snapshot is a frozen version of a config, see the top of this file
+type Snapshot struct {
+	ID     UUID
+	Time   time.Time
+	Label  string
+	config CfgMap // objects are frozen, and the map is never changed after creation
+}
+
+// writable returns the object with UUID u, first replacing it with a copy in cm if it's frozen.
+// Observers are not told, as nothing has changed yet, but the copy reports later changes to the observers of
+// cm, not to those of whatever map the frozen object was in. Returns nil if not found.
+func (cm CfgMap) writable(u UUID) *CfgObj {
+	co, found := cm[u]
+	if !found || co == nil {
+		return nil
+	}
+	if !co.frozen {
+		return co
+	}
+	c := co.Copy()
+	c.obs = observersOf(cm)
+	cm[u] = c
+	return c
+}
+
+// shallowCopy returns a new map with the same objects
+func (cm CfgMap) shallowCopy() CfgMap {
+	c := make(CfgMap, len(cm))
+	for k, v := range cm {
+		c[k] = v
+	}
+	return c
+}
+
+// Edit returns the object with UUID u, ready to be changed. If the object is shared with a snapshot, it's
+// copied first, and the copy replaces it in the config.
+func (nc *NagiosCfg) Edit(u UUID) (*CfgObj, bool) {
+	co := nc.Config.writable(u)
+	return co, co != nil
+}
+
+// Frozen returns true if the object is shared with a snapshot, and can not be changed
+func (co *CfgObj) Frozen() bool {
+	return co.frozen
+}
+
+// Snapshot freezes the config as it is now. This is cheap, as no objects are copied.
+func (nc *NagiosCfg) Snapshot(label string) *Snapshot {
+	for _, co := range nc.Config {
+		co.frozen = true
+	}
+	return &Snapshot{
+		ID:     NewUUIDv1(),
+		Time:   time.Now(),
+		Label:  label,
+		config: nc.Config.shallowCopy(),
+	}
+}
+
+// Config returns the objects in the snapshot. The map can be changed freely, but the objects can not.
+func (s *Snapshot) Config() CfgMap {
+	return s.config.shallowCopy()
+}
+
+// Len returns the number of objects in the snapshot
+func (s *Snapshot) Len() int {
+	return len(s.config)
+}
+
+// Get returns the object with UUID u. It's frozen, and can not be changed.
+func (s *Snapshot) Get(u UUID) (*CfgObj, bool) {
+	co, found := s.config[u]
+	return co, found
+}
+
+// Derive returns a new config starting out as the snapshot, which can be edited without changing the snapshot
+func (s *Snapshot) Derive() *NagiosCfg {
+	nc := NewNagiosCfg()
+	nc.Config = s.config.shallowCopy()
+	return nc
+}
+
+// Diff returns the changes from s to s2. Objects shared by both are skipped without comparing them.
+func (s *Snapshot) Diff(s2 *Snapshot) *CfgDiff {
+	return diffShared(s.config, s2.config)
+}
+
+// diffShared returns the changes from cm to cm2, where unchanged objects are shared by both, and skipped
+// without comparing them
+func diffShared(cm, cm2 CfgMap) *CfgDiff {
+	a := make(CfgMap)
+	b := make(CfgMap)
+	for u, co := range cm {
+		if cm2[u] != co {
+			a[u] = co
+		}
+	}
+	for u, co := range cm2 {
+		if cm[u] != co {
+			b[u] = co
+		}
+	}
+	return DiffMaps(a, b)
+}
+
+// Promote makes the config equal to the snapshot. The config is changed in place, through CfgMap.SetByUUID and
+// DelByUUID, so that an index stays up to date, and a journal records it as one step that can be undone.
+// The current matches are cleared.
+func (nc *NagiosCfg) Promote(s *Snapshot) {
+	defer beginBatch(nc.Config, "Promote")()
+	for _, u := range nc.Config.Keys() {
+		if _, found := s.config[u]; !found {
+			nc.Config.DelByUUID(u)
+		}
+	}
+	for _, u := range s.config.Keys() {
+		if co := s.config[u]; nc.Config[u] != co {
+			nc.Config.SetByUUID(u, co) // the object is frozen, so it's not changed by this
+		}
+	}
+	nc.ClearMatches()
+}
@@ -0,0 +1,156 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"fmt"
+	"testing"
+)
+
+// panics returns true if f panics
+func panics(f func()) (p bool) {
+	defer func() {
+		p = recover() != nil
+	}()
+	f()
+	return false
+}
+
+func TestSnapshotCopyOnWrite(t *testing.T) {
+	nc := loadDiffCfg(t, querycfgstr)
+	base := nc.Snapshot("base")
+	host := nc.Config[nc.Config.FilterType(T_HOST)[0]]
+
+	if !panics(func() { host.Set("address", "10.0.0.99") }) || host.Props["address"] != "10.0.0.1" {
+		t.Error("Set on a frozen object should panic, and not change it")
+	}
+	if !panics(func() { host.Del("address") }) || host.Props["address"] != "10.0.0.1" {
+		t.Error("Del on a frozen object should panic, and not change it")
+	}
+	if host.AddList("parents", ",", "a", "b") || host.Props["parents"] != "" {
+		t.Error("AddList should refuse to change frozen objects")
+	}
+	if _, err := host.TrySet("notes", "x"); err != ErrFrozen {
+		t.Errorf("Expected ErrFrozen, got %v", err)
+	}
+	if _, err := host.TryDel("address"); err != ErrFrozen {
+		t.Errorf("Expected ErrFrozen, got %v", err)
+	}
+	if n := host.SetKeys([]string{"notes", "alias"}, []string{"x", "y"}); n != 0 {
+		t.Errorf("Expected refused keys not to be counted, got %d", n)
+	}
+
+	a := base.Derive()
+	a.matches = a.Config.FilterType(T_SERVICE)
+	if n := a.SetKeys([]string{"notes"}, []string{"version a"}); n != 3 {
+		t.Errorf("Expected 3 changes, got %d", n)
+	}
+
+	b := base.Derive()
+	p := &Patch{Ops: []PatchOp{{Op: PATCH_SET, Type: "host", ID: "web01", Props: map[string]string{"address": "10.0.0.2"}}}}
+	if _, err := b.ApplyPatch(p); err != nil {
+		t.Fatal(err)
+	}
+	co, _ := b.Edit(host.UUID)
+	co.Set("notes", "version b")
+
+	if host.Props["address"] != "10.0.0.1" || host.Props["notes"] != "" {
+		t.Error("Editing a derived config should not change the snapshot")
+	}
+	for _, u := range base.Config().FilterType(T_SERVICE) {
+		if co, _ := base.Get(u); co.Props["notes"] == "version a" {
+			t.Error("Editing a derived config should not change the snapshot")
+		}
+	}
+	if b.Config[host.UUID].Props["address"] != "10.0.0.2" || b.Config[host.UUID].Props["notes"] != "version b" {
+		t.Errorf("Unexpected host in b: %v", b.Config[host.UUID].Props)
+	}
+	for u, co := range b.Config {
+		if u != host.UUID && co != nc.Config[u] {
+			t.Error("Unchanged objects should be shared")
+		}
+	}
+}
+
+func TestSnapshotDiffPromote(t *testing.T) {
+	nc := loadDiffCfg(t, querycfgstr)
+	base := nc.Snapshot("base")
+
+	d := base.Derive()
+	d.SearchQuery(MustParseQuery(`host_name=db01`))
+	d.DeleteMatches()
+	d.SearchQuery(MustParseQuery(`type=host`))
+	d.SetKeys([]string{"address"}, []string{"10.0.0.2"})
+	next := d.Snapshot("next")
+
+	diff := base.Diff(next)
+	if len(diff.Removed) != 1 || len(diff.Modified) != 1 || len(diff.Added) != 0 {
+		t.Fatalf("Expected 1 removed and 1 modified, got %+v", diff)
+	}
+	if diff.Modified[0].Changes[0].Key != "address" {
+		t.Errorf("Unexpected change: %+v", diff.Modified[0].Changes)
+	}
+
+	j := nc.EnableJournal()
+	defer nc.DisableJournal()
+	nc.Promote(next)
+	if nc.Len() != next.Len() || len(base.Diff(nc.Snapshot("promoted")).Modified) != 1 {
+		t.Error("Config should be equal to the promoted snapshot")
+	}
+	if err := j.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if nc.Len() != base.Len() || len(nc.Snapshot("undone").Diff(base).Modified) != 0 {
+		t.Error("Undo should revert the whole promote")
+	}
+}
+
+func TestSnapshotPromoteShared(t *testing.T) {
+	nc := loadDiffCfg(t, querycfgstr)
+	base := nc.Snapshot("base")
+
+	d := base.Derive()
+	var added UUIDs
+	for i := 0; i < 10; i++ {
+		co := NewCfgObjWithUUID(T_COMMAND)
+		co.Add("command_name", fmt.Sprintf("cmd_%d", i))
+		d.Config.AddByUUID(co.UUID, co)
+		addOrder(co.UUID)
+		added = append(added, co.UUID)
+	}
+	next := d.Snapshot("next")
+
+	other := base.Derive()
+	other.EnableIndex() // observes the shared objects, without setting their observers
+	defer other.DisableIndex()
+
+	j := nc.EnableJournal()
+	defer nc.DisableJournal()
+	nc.Promote(next)
+	var order UUIDs
+	for _, e := range j.Entries() {
+		order = append(order, e.UUID)
+	}
+	if fmt.Sprint(order) != fmt.Sprint(added) {
+		t.Errorf("Expected objects to be added in read order:\n%v\n%v", added, order)
+	}
+	for _, u := range next.Config().Keys() {
+		if co, _ := next.Get(u); co.obs != nil {
+			t.Errorf("Shared object %s should not have observers set", co.UUID)
+		}
+	}
+}
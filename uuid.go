@@ -25,6 +25,7 @@ adjusted to only cater for my specific needs here.
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/sha1"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
@@ -42,6 +43,12 @@ var (
 	hwAddr   [6]byte
 )
 
+// Predefined namespaces for name based UUIDs
+var (
+	NAMESPACE_URL       = UUID{0x6b, 0xa7, 0xb8, 0x11, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+	NAMESPACE_NAGIOSCFG = NewUUIDv5(NAMESPACE_URL, "https://github.com/vgtmnm/nagioscfg") // used for object IDs
+)
+
 func NewUUIDv1() UUID {
 	u := UUID{}
 
@@ -86,6 +93,21 @@ func NewUUIDv1() UUID {
 	return u
 }
 
+// NewUUIDv5 returns a UUID based on the SHA-1 hash of the namespace and name.
+// The same namespace and name always give the same UUID.
+func NewUUIDv5(ns UUID, name string) UUID {
+	u := UUID{}
+	h := sha1.New()
+	h.Write(ns[:])
+	h.Write([]byte(name))
+	copy(u[:], h.Sum(nil))
+
+	u[6] = (u[6] & 0x0f) | (5 << 4) // set version 5
+	u[8] = (u[8] & 0xbf) | 0x80     // set variant
+
+	return u
+}
+
 func (u UUID) Equals(u2 UUID) bool {
 	return bytes.Equal(u[:], u2[:])
 }
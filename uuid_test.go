@@ -52,3 +52,13 @@ func TestUUIDFromString(t *testing.T) {
 	t.Logf("s1: %s", s1)
 	t.Logf("u1: %s", u1)
 }
+
+func TestNewUUIDv5(t *testing.T) {
+	u := NewUUIDv5(NAMESPACE_URL, "http://python.org/")
+	if u.String() != "4c565f0d-3f5a-5890-b41b-20cf47701c5e" {
+		t.Errorf("Unexpected UUIDv5: %s", u)
+	}
+	if !u.Equals(NewUUIDv5(NAMESPACE_URL, "http://python.org/")) {
+		t.Error("UUIDv5 should be the same for the same name")
+	}
+}
@@ -0,0 +1,367 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+Finding what refers to an object, and deleting objects without leaving references to them behind.
+WhereUsed lists the objects and keys referring to an object, using the graph from graph.go, so references are
+found the same way, as written in each object. A service applied through hostgroup_name is found on each of the
+hosts it applies to, so that servicegroups and dependencies naming it on one of them count as references.
+
+DeleteMatchesMode deletes the matches in one of three modes:
+
+	DEL_FORCE:   delete, no matter what refers to them, same as DeleteMatches
+	DEL_SAFE:    refuse to delete anything if an object not deleted refers to any of them
+	DEL_CASCADE: delete, and clean up the references
+
+Cleaning up removes the name from the list value referring to it, and the key if nothing is left. Objects that
+mean nothing without the reference are deleted as well, and cleaned up after in turn: services losing the last
+host or hostgroup, or their check_command, dependencies losing the last master or dependent, escalations losing
+the last host or contact, and extinfo losing its host. Hosts are kept without a check_command, which Nagios
+allows, as they are then never actively checked. Dependencies, escalations and extinfo naming a deleted service lose the
+host and description only where no other named service is left, and are deleted when no description is left.
+Templates (register 0) are only ever cleaned up, never deleted.
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+type DelMode int
+
+const (
+	DEL_FORCE DelMode = iota
+	DEL_SAFE
+	DEL_CASCADE
+)
+
+// Usage is a reference to an object, from Key in Obj
+type Usage struct {
+	Obj *CfgObj
+	Key string
+}
+
+// cascadeAnchors lists, by type, sets of keys where an object needs at least one of them. An object losing
+// the last key of a set in a cascading delete is deleted too.
+var cascadeAnchors = map[CfgType][][]string{
+	T_SERVICE: {
+		{"host_name", "hostgroup_name"},
+		{"check_command"}, // Nagios refuses services without one, unlike hosts
+	},
+	T_HOSTDEPENDENCY: {
+		{"host_name", "hostgroup_name"},
+		{"dependent_host_name", "dependent_hostgroup_name"},
+	},
+	T_HOSTESCALATION: {
+		{"host_name", "hostgroup_name"},
+		{"contacts", "contact_groups"},
+	},
+	T_HOSTEXTINFO: {
+		{"host_name"},
+	},
+	T_SERVICEDEPENDENCY: {
+		{"host_name", "hostgroup_name", "servicegroup_name"},
+		{"dependent_host_name", "dependent_hostgroup_name", "dependent_servicegroup_name"},
+	},
+	T_SERVICEESCALATION: {
+		{"host_name", "hostgroup_name", "servicegroup_name"},
+		{"contacts", "contact_groups"},
+	},
+	T_SERVICEEXTINFO: {
+		{"host_name"},
+	},
+}
+
+// WhereUsed returns every reference to co, in the graph
+func (g *Graph) WhereUsed(co *CfgObj) []Usage {
+	var res []Usage
+	seen := make(map[Usage]bool)
+	for _, e := range g.In(co) {
+		u := Usage{Obj: e.From, Key: e.Key}
+		if e.Key == EDGE_DEPENDS || seen[u] {
+			continue // dependencies are already there as references from the dependency object
+		}
+		seen[u] = true
+		res = append(res, u)
+	}
+	return res
+}
+
+// WhereUsed returns every reference to co from objects in cm. When looking up more than a few objects,
+// build a Graph once and use Graph.WhereUsed instead.
+func (cm CfgMap) WhereUsed(co *CfgObj) []Usage {
+	return NewGraph(cm).WhereUsed(co)
+}
+
+// WhereUsed returns every reference to co in the config
+func (nc *NagiosCfg) WhereUsed(co *CfgObj) []Usage {
+	return nc.Config.WhereUsed(co)
+}
+
+// String gives the usage like "service web01;HTTP check_command"
+func (u Usage) String() string {
+	return fmt.Sprintf("%s %s %s", u.Obj.Type.String(), diffID(u.Obj), u.Key)
+}
+
+// DeleteMatchesMode deletes all matched objects, in the given mode, see the top of this file. Returns the
+// deleted objects, including those deleted by a cascade. Nothing is deleted on errors.
+func (nc *NagiosCfg) DeleteMatchesMode(mode DelMode) (CfgMap, error) {
+	switch mode {
+	case DEL_FORCE:
+		return nc.DeleteMatches(), nil
+	case DEL_SAFE:
+		if err := nc.checkUnused(); err != nil {
+			return nil, err
+		}
+		return nc.DeleteMatches(), nil
+	case DEL_CASCADE:
+		return nc.deleteCascade(), nil
+	}
+	return nil, fmt.Errorf("Unknown delete mode: %d %s", mode, dbgStr(true))
+}
+
+// checkUnused returns an error for the first match referred to by an object not matched
+func (nc *NagiosCfg) checkUnused() error {
+	g := NewGraph(nc.Config)
+	del := make(map[UUID]bool, len(nc.matches))
+	for _, u := range nc.matches {
+		del[u] = true
+	}
+	for _, u := range nc.matches {
+		co, found := nc.Config[u]
+		if !found {
+			continue
+		}
+		var uses []Usage
+		for _, use := range g.WhereUsed(co) {
+			if !del[use.Obj.UUID] {
+				uses = append(uses, use)
+			}
+		}
+		if len(uses) > 0 {
+			return fmt.Errorf("Refusing to delete %s %s, used by %s (%d references in total) %s", co.Type.String(), diffID(co), uses[0], len(uses), dbgStr(true))
+		}
+	}
+	return nil
+}
+
+// cascade is the state of a cascading delete
+type cascade struct {
+	g       *Graph // of the config before anything was deleted, so references can still be followed
+	deleted CfgMap
+}
+
+func (nc *NagiosCfg) deleteCascade() CfgMap {
+	if nc.matches.Empty() {
+		return nil
+	}
+	defer beginBatch(nc.Config, "DeleteMatches")()
+	c := &cascade{g: NewGraph(nc.Config), deleted: make(CfgMap)}
+	queue := append(UUIDs{}, nc.matches...)
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		if _, found := c.deleted[u]; found {
+			continue
+		}
+		co := nc.Config.DelByUUID(u)
+		if co == nil {
+			continue
+		}
+		c.deleted[u] = co
+		if co.Type == T_HOST {
+			// services left on other hosts are no longer in servicegroups for this one
+			host := co.Props["host_name"]
+			for _, desc := range c.g.names.descs[host] {
+				for _, use := range c.g.WhereUsed(c.g.names.service(host, desc)) {
+					if sg := nc.Config.writable(use.Obj.UUID); sg != nil && use.Key == "members" {
+						removeMembers(sg, func(h, d string) bool { return h == host && d == desc })
+					}
+				}
+			}
+		}
+		for _, use := range c.g.WhereUsed(co) {
+			if _, found := c.deleted[use.Obj.UUID]; found {
+				continue
+			}
+			ref := nc.Config.writable(use.Obj.UUID)
+			if ref == nil {
+				continue
+			}
+			if c.removeRef(ref, use.Key, co) {
+				queue = append(queue, ref.UUID)
+			}
+		}
+	}
+	nc.ClearMatches()
+	return c.deleted
+}
+
+// removeRef removes the reference to target from key in co, returning true if co should be deleted as well
+func (c *cascade) removeRef(co *CfgObj, key string, target *CfgObj) bool {
+	val := co.Props[key]
+	var keep []string
+	switch {
+	case key == "check_command" || key == "event_handler":
+	case key == "members" && target.Type == T_SERVICE:
+		removeMembers(co, func(h, d string) bool { return c.is(h, d, target) })
+		return false
+	case target.Type == T_SERVICE:
+		return c.removeServiceRef(co, key, target)
+	default:
+		name := target.Props["name"]
+		if key != "use" {
+			name = target.Props[identityKeys[target.Type][0]]
+		}
+		for _, v := range splitList(strings.TrimPrefix(val, TPL_ADDITIVE)) {
+			n := strings.TrimPrefix(v, LST_EXCLUDE)
+			if target.Type == T_COMMAND {
+				n = cmdName(n)
+			}
+			if n != name {
+				keep = append(keep, v)
+			}
+		}
+	}
+	if len(keep) > 0 {
+		setList(co, key, val, keep)
+		return false
+	}
+	co.Del(key)
+	return lostAnchor(co, key)
+}
+
+// is returns true if the graph found target as the service with description desc on host
+func (c *cascade) is(host, desc string, target *CfgObj) bool {
+	svc := c.g.names.service(host, desc)
+	return svc != nil && svc.UUID == target.UUID
+}
+
+// gone returns true if the service with description desc on host has been deleted
+func (c *cascade) gone(host, desc string) bool {
+	svc := c.g.names.service(host, desc)
+	if svc == nil {
+		return false
+	}
+	_, found := c.deleted[svc.UUID]
+	return found
+}
+
+// removeServiceRef removes the deleted service target from a dependency, escalation or extinfo naming it by
+// key, the service_description or dependent_service_description. The hosts are given in the host_name key
+// going with it. A host is removed when none of the described services are left on it, and a description when
+// it's not left on any of the hosts, unless hostgroups are given as well. co should be deleted when no
+// description is left, or the hosts are the last anchor.
+func (c *cascade) removeServiceRef(co *CfgObj, key string, target *CfgObj) bool {
+	hostKey := strings.TrimSuffix(key, "service_description") + "host_name"
+	sharedHosts := false
+	if _, found := co.Get(hostKey); !found && hostKey != "host_name" {
+		hostKey, sharedHosts = "host_name", true // the dependent services are on the master hosts
+	}
+	groupKey := strings.TrimSuffix(hostKey, "host_name") + "hostgroup_name"
+	hostVal, descVal := co.Props[hostKey], co.Props[key]
+	hosts := splitList(strings.TrimPrefix(hostVal, TPL_ADDITIVE))
+	descs := splitList(strings.TrimPrefix(descVal, TPL_ADDITIVE))
+
+	// only plain names can be gone, "*", exclusions and the like stay
+	plain := func(n string) bool {
+		return n != "" && n != LST_ALL && n != TPL_NULL && !strings.HasPrefix(n, LST_EXCLUDE)
+	}
+	_, groups := co.Get(groupKey)
+	var keepHosts, keepDescs []string
+	for _, h := range hosts {
+		left := !plain(h)
+		for _, d := range descs {
+			left = left || !c.gone(h, d)
+		}
+		if left || sharedHosts {
+			keepHosts = append(keepHosts, h)
+		}
+	}
+	for _, d := range descs {
+		left := !plain(d) || groups
+		for _, h := range hosts {
+			left = left || !plain(h) || !c.gone(h, d)
+		}
+		if left {
+			keepDescs = append(keepDescs, d)
+		}
+	}
+	if len(keepDescs) == 0 {
+		return !co.isTemplate()
+	}
+	if len(keepDescs) < len(descs) {
+		setList(co, key, descVal, keepDescs)
+	}
+	if len(keepHosts) == len(hosts) {
+		return false
+	}
+	if len(keepHosts) > 0 {
+		setList(co, hostKey, hostVal, keepHosts)
+		return false
+	}
+	co.Del(hostKey)
+	return lostAnchor(co, hostKey)
+}
+
+// setList sets key in co to the list, keeping the additive prefix of the old value
+func setList(co *CfgObj, key, old string, list []string) {
+	nval := strings.Join(list, SEP_LST)
+	if strings.HasPrefix(old, TPL_ADDITIVE) {
+		nval = TPL_ADDITIVE + nval
+	}
+	co.Set(key, nval)
+}
+
+// removeMembers removes the host,description pairs for which drop returns true from the members of the
+// servicegroup sg
+func removeMembers(sg *CfgObj, drop func(host, desc string) bool) {
+	pairs := splitList(sg.Props["members"])
+	var keep []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if !drop(pairs[i], pairs[i+1]) {
+			keep = append(keep, pairs[i], pairs[i+1])
+		}
+	}
+	switch {
+	case len(keep) == len(pairs):
+	case len(keep) == 0:
+		sg.Del("members")
+	default:
+		sg.Set("members", strings.Join(keep, SEP_LST))
+	}
+}
+
+// lostAnchor returns true if co is not a template, and has none left of the anchor keys that key belongs to
+func lostAnchor(co *CfgObj, key string) bool {
+	if co.isTemplate() {
+		return false
+	}
+	for _, keys := range cascadeAnchors[co.Type] {
+		if !hasKey(keys, key) {
+			continue
+		}
+		for _, k := range keys {
+			if _, found := co.Get(k); found {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"testing"
+)
+
+var whereusedcfgstr string = `define command{
+	command_name check_ping
+	command_line $USER1$/check_ping
+}
+define command{
+	command_name notify_mail
+	command_line /bin/mail
+}
+define contact{
+	contact_name admin
+	host_notification_commands notify_mail
+	service_notification_commands notify_mail,notify_sms
+}
+define contactgroup{
+	contactgroup_name admins
+	members admin,other
+}
+define host{
+	host_name web01
+	check_command check_ping!100.0,20%!500.0,60%
+	contacts admin
+}
+define host{
+	host_name web02
+	parents web01
+	contact_groups admins
+}
+define service{
+	host_name web01
+	service_description PING
+	check_command check_ping
+}
+define service{
+	host_name web01,web02
+	service_description HTTP
+}
+define servicegroup{
+	servicegroup_name all
+	members web01,PING,web02,HTTP
+}
+define servicedependency{
+	host_name web01
+	service_description PING
+	dependent_host_name web02
+	dependent_service_description HTTP
+}
+define hostescalation{
+	host_name web02
+	contacts admin
+}
+`
+
+func usageStrings(uses []Usage) []string {
+	res := make([]string, len(uses))
+	for i := range uses {
+		res[i] = uses[i].String()
+	}
+	return res
+}
+
+func TestWhereUsed(t *testing.T) {
+	nc := loadDiffCfg(t, whereusedcfgstr)
+	got := usageStrings(nc.WhereUsed(nc.GetCommand("check_ping")))
+	if !sameStrings(got, []string{"host web01 check_command", "service web01;PING check_command"}) {
+		t.Errorf("Unexpected uses of check_ping: %v", got)
+	}
+	admin, _ := nc.Config.GetByIdentity(T_CONTACT, "admin")
+	got = usageStrings(nc.WhereUsed(admin))
+	if !sameStrings(got, []string{"contactgroup admins members", "host web01 contacts", "hostescalation host_name=web02 contacts"}) {
+		t.Errorf("Unexpected uses of admin: %v", got)
+	}
+	if len(nc.WhereUsed(nc.GetHost("web02"))) != 3 {
+		t.Errorf("Expected 3 uses of web02, got %v", usageStrings(nc.WhereUsed(nc.GetHost("web02"))))
+	}
+}
+
+func TestDeleteMatchesSafe(t *testing.T) {
+	nc := loadDiffCfg(t, whereusedcfgstr)
+	nc.SearchQuery(MustParseQuery(`type=command`))
+	if _, err := nc.DeleteMatchesMode(DEL_SAFE); err == nil || nc.Len() != 11 {
+		t.Fatal("Deleting commands in use should be refused")
+	}
+
+	nc.ClearMatches()
+	nc.SearchQuery(MustParseQuery(`type=hostescalation OR type=servicedependency OR type=servicegroup OR service_description=PING`))
+	if deleted, err := nc.DeleteMatchesMode(DEL_SAFE); err != nil || len(deleted) != 4 {
+		t.Errorf("Expected 4 deleted, as PING is only used by the others, got %d, %v", len(deleted), err)
+	}
+}
+
+func TestDeleteMatchesCascade(t *testing.T) {
+	nc := loadDiffCfg(t, whereusedcfgstr)
+	j := nc.EnableJournal()
+	defer nc.DisableJournal()
+
+	nc.SearchQuery(MustParseQuery(`type=host AND host_name=web02`))
+	deleted, err := nc.DeleteMatchesMode(DEL_CASCADE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// web02, the escalation for it, and the dependency on HTTP, which is left on web01 only
+	if len(deleted) != 3 {
+		t.Errorf("Expected 3 deleted, got %d", len(deleted))
+	}
+	svc := nc.GetService("web01", "HTTP")
+	if svc == nil || svc.Props["host_name"] != "web01" {
+		t.Errorf("Expected HTTP to be left on web01, got %v", svc)
+	}
+	sg, _ := nc.Config.GetByIdentity(T_SERVICEGROUP, "all")
+	if sg.Props["members"] != "web01,PING" {
+		t.Errorf("Expected web02,HTTP removed from servicegroup, got %q", sg.Props["members"])
+	}
+
+	nc.SearchQuery(MustParseQuery(`type=command`))
+	if deleted, _ = nc.DeleteMatchesMode(DEL_CASCADE); len(deleted) != 3 {
+		t.Errorf("Expected the commands and PING deleted, got %d", len(deleted))
+	}
+	if _, found := nc.GetHost("web01").Get("check_command"); found {
+		t.Error("Expected check_command removed from web01")
+	}
+	if nc.GetService("web01", "PING") != nil {
+		t.Error("Expected PING deleted with its check_command")
+	}
+	if sg, _ := nc.Config.GetByIdentity(T_SERVICEGROUP, "all"); sg.Props["members"] != "" {
+		t.Errorf("Expected web01,PING removed from servicegroup, got %q", sg.Props["members"])
+	}
+	admin, _ := nc.Config.GetByIdentity(T_CONTACT, "admin")
+	if admin.Props["service_notification_commands"] != "notify_sms" {
+		t.Errorf("Expected notify_mail removed, got %v", admin.Props)
+	}
+
+	if err := j.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if nc.Len() != 11 || nc.GetHost("web02") == nil {
+		t.Error("Undo should bring back all that was deleted by the cascades")
+	}
+
+	nc.SearchQuery(MustParseQuery(`type=host AND host_name=web01`))
+	if deleted, err = nc.DeleteMatchesMode(DEL_CASCADE); err != nil {
+		t.Fatal(err)
+	}
+	// web01, PING, which was only on web01, and the dependency on PING
+	if len(deleted) != 3 || nc.GetService("web01", "PING") != nil {
+		t.Errorf("Expected 3 deleted, got %d", len(deleted))
+	}
+	sg, _ = nc.Config.GetByIdentity(T_SERVICEGROUP, "all")
+	if sg.Props["members"] != "web02,HTTP" {
+		t.Errorf("Expected only web02,HTTP left in servicegroup, got %q", sg.Props["members"])
+	}
+}
+
+var cascadesvccfgstr string = `define contact{
+	contact_name admin
+}
+define host{
+	host_name web01
+}
+define host{
+	host_name web02
+}
+define service{
+	host_name web01
+	service_description PING
+}
+define service{
+	host_name web02
+	service_description PING
+}
+define service{
+	host_name web01
+	service_description HTTP
+}
+define serviceescalation{
+	host_name web01,web02
+	service_description PING
+	contacts admin
+}
+define servicedependency{
+	host_name web01
+	service_description PING,HTTP
+	dependent_host_name web02
+	dependent_service_description PING
+}
+`
+
+func TestDeleteMatchesCascadeService(t *testing.T) {
+	nc := loadDiffCfg(t, cascadesvccfgstr)
+	dep := nc.Config.FilterType(T_SERVICEDEPENDENCY)[0]
+	esc := nc.Config.FilterType(T_SERVICEESCALATION)[0]
+
+	nc.matches = UUIDs{nc.GetService("web01", "HTTP").UUID}
+	if deleted, _ := nc.DeleteMatchesMode(DEL_CASCADE); len(deleted) != 1 {
+		t.Errorf("Expected only HTTP deleted, got %d", len(deleted))
+	}
+	if co := nc.Config[dep]; co == nil || co.Props["service_description"] != "PING" || co.Props["host_name"] != "web01" {
+		t.Errorf("Expected the dependency left on web01;PING, got %v", co)
+	}
+
+	nc.matches = UUIDs{nc.GetService("web01", "PING").UUID}
+	if deleted, _ := nc.DeleteMatchesMode(DEL_CASCADE); len(deleted) != 2 {
+		t.Errorf("Expected PING and the dependency deleted, got %d", len(deleted))
+	}
+	if _, found := nc.Config[dep]; found {
+		t.Error("Expected the dependency deleted with its last master")
+	}
+	if co := nc.Config[esc]; co == nil || co.Props["host_name"] != "web02" || co.Props["service_description"] != "PING" {
+		t.Errorf("Expected the escalation left on web02;PING, got %v", co)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+Writer is the counterpart of Reader, modeled on encoding/csv.Writer.
+All printing of objects in Nagios format goes through here.
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A Writer writes CfgObjs in Nagios format.
+// Writes are buffered, so Flush must be called to make sure all data has been written to the underlying io.Writer.
+// Any errors that occurred should be checked by calling the Error method.
+type Writer struct {
+	Sorted    bool // print properties in the order given by CfgObj.SortedKeys, otherwise in random order
+	AutoAlign bool // align on the longest key of each object (but not below DEF_ALIGN), instead of CfgObj.Align
+	Separate  bool // write a blank line after each object
+	w         *bufio.Writer
+}
+
+// NewWriter returns a new Writer that writes to w, with properties sorted and a blank line after each object
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		Sorted:   true,
+		Separate: true,
+		w:        bufio.NewWriter(w),
+	}
+}
+
+// Write writes a single object to w
+func (w *Writer) Write(co *CfgObj) error {
+	align := co.Align
+	if w.AutoAlign {
+		// the reader relies on values starting after DEF_ALIGN to tell a '{' in a value from the start of a definition
+		align = co.LongestKey() + 2
+		if align < DEF_ALIGN {
+			align = DEF_ALIGN
+		}
+	}
+	prefix := strings.Repeat(" ", co.Indent)
+	fstr := fmt.Sprintf("%s%s%d%s", prefix, "%-", align, "s%s\n")
+
+	comment, _ := co.commentFor() // this might fail, but don't care yet
+	fmt.Fprintf(w.w, "%s\n", comment)
+	fmt.Fprintf(w.w, "define %s{\n", co.Type.String())
+	if w.Sorted {
+		co.PrintPropsSorted(w.w, fstr)
+	} else {
+		co.PrintProps(w.w, fstr)
+	}
+	fmt.Fprintf(w.w, "%s}\n", prefix)
+	if w.Separate {
+		w.w.WriteByte('\n')
+	}
+	return w.Error()
+}
+
+// WriteAll writes multiple objects to w using Write and then calls Flush
+func (w *Writer) WriteAll(cos CfgObjs) error {
+	for i := range cos {
+		err := w.Write(cos[i])
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Flush writes any buffered data to the underlying io.Writer.
+// To check if an error occurred during the Flush, call Error.
+func (w *Writer) Flush() {
+	w.w.Flush()
+}
+
+// Error reports any error that has occurred during a previous Write or Flush
+func (w *Writer) Error() error {
+	_, err := w.w.Write(nil)
+	return err
+}
@@ -0,0 +1,110 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var errWriteFailed = errors.New("write failed")
+
+// failWriter fails every write
+type failWriter struct{}
+
+func (fw failWriter) Write(p []byte) (int, error) {
+	return 0, errWriteFailed
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	cm, err := NewReader(strings.NewReader(cfgobjstr)).ReadAllMap("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs := make(CfgObjs, 0, cm.Len())
+	for _, k := range cm.Keys() {
+		objs = append(objs, cm[k])
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.AutoAlign = true
+	err = w.WriteAll(objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(buf.String(), "}\n\n") {
+		t.Errorf("Expected blank line after last object, got:\n%s", buf.String())
+	}
+
+	cm2, err := NewReader(&buf).ReadAllMap("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm2.Len() != len(objs) {
+		t.Fatalf("Expected %d objects, got %d", len(objs), cm2.Len())
+	}
+	for i, k := range cm2.Keys() {
+		if objs[i].Type != cm2[k].Type || !reflect.DeepEqual(objs[i].Props, cm2[k].Props) {
+			t.Errorf("Objects differ:\n%+v\n%+v", objs[i], cm2[k])
+		}
+	}
+}
+
+func TestWriterSeparate(t *testing.T) {
+	co := NewCfgObj(T_COMMAND)
+	co.Add("command_name", "check_ping")
+	co.Add("command_line", "$USER1$/check_ping")
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Separate = false
+	w.Write(co)
+	w.Flush()
+
+	var buf2 bytes.Buffer
+	co.Print(&buf2, true)
+	if buf.String() != buf2.String() {
+		t.Errorf("Expected same output as CfgObj.Print:\n%s\n%s", buf.String(), buf2.String())
+	}
+}
+
+func TestWriterError(t *testing.T) {
+	co := NewCfgObj(T_HOST)
+	co.Add("host_name", "h1")
+
+	w := NewWriter(failWriter{})
+	w.Write(co) // buffered, so this might not fail yet
+	w.Flush()
+	if w.Error() != errWriteFailed {
+		t.Errorf("Expected %q from Error, got %v", errWriteFailed, w.Error())
+	}
+
+	if err := co.Print(failWriter{}, true); err != errWriteFailed {
+		t.Errorf("Expected %q from CfgObj.Print, got %v", errWriteFailed, err)
+	}
+	cm := CfgMap{co.UUID: co}
+	if err := cm.Print(failWriter{}, true); err != errWriteFailed {
+		t.Errorf("Expected %q from CfgMap.Print, got %v", errWriteFailed, err)
+	}
+	if err := co.PrintPropsSorted(failWriter{}, "%s %s\n"); err != errWriteFailed {
+		t.Errorf("Expected %q from PrintPropsSorted, got %v", errWriteFailed, err)
+	}
+}
@@ -0,0 +1,264 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+/*
+YAML import/export, meant for humans and for other tools generating config.
+A CfgMap is grouped by object type and then by object name, like this:
+
+	host:
+	  web01:
+	    fileid: /etc/nagios/hosts.cfg
+	    use: [generic-host, graphed-host]
+	    host_name: web01
+	    address: 10.0.0.1
+	    _SNMP_COMMUNITY: public
+	service:
+	  web01;HTTP:
+	    use: [generic-service]
+	    host_name: web01
+	    service_description: HTTP
+
+The names used as keys are just labels (CfgObj.Identity), the properties are what counts. Objects without
+an identity, or with the same identity as an earlier object of the same type, are listed under their UUID.
+"use" is given as a list, all other values as they are in Nagios format.
+A single CfgObj has the same layout as above, but with an extra "type" key.
+*/
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"sort"
+	"strings"
+)
+
+const (
+	YAML_KEY_TYPE   string = "type"
+	YAML_KEY_FILEID string = "fileid"
+	YAML_KEY_USE    string = "use"
+)
+
+// yamlValue holds a property value from YAML, which is either a scalar or a list
+type yamlValue struct {
+	str    string
+	list   []string
+	isList bool
+}
+
+func (yv *yamlValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// Decoding into a string keeps the value as written, so e.g. "notification_options: n" does not turn into "false"
+	if err := unmarshal(&yv.str); err == nil {
+		return nil
+	}
+	yv.isList = true
+	return unmarshal(&yv.list)
+}
+
+func (yv yamlValue) String() string {
+	if yv.isList {
+		return strings.Join(yv.list, SEP_LST)
+	}
+	return yv.str
+}
+
+// yamlProps returns the properties of the object in print order, with "use" as a list
+func (co *CfgObj) yamlProps(withType bool) yaml.MapSlice {
+	ms := make(yaml.MapSlice, 0, len(co.Props)+2)
+	if withType {
+		ms = append(ms, yaml.MapItem{Key: YAML_KEY_TYPE, Value: co.Type.String()})
+	}
+	if co.FileID != "" {
+		ms = append(ms, yaml.MapItem{Key: YAML_KEY_FILEID, Value: co.FileID})
+	}
+	for _, k := range co.SortedKeys() {
+		if k == YAML_KEY_USE {
+			ms = append(ms, yaml.MapItem{Key: k, Value: co.GetList(k, SEP_LST)})
+			continue
+		}
+		ms = append(ms, yaml.MapItem{Key: k, Value: co.Props[k]})
+	}
+	return ms
+}
+
+// cfgObjFromYAML creates a new object of the given type from decoded YAML properties
+func cfgObjFromYAML(ct CfgType, props map[string]yamlValue) (*CfgObj, error) {
+	co := NewCfgObjWithUUID(ct)
+	for k, v := range props {
+		switch k {
+		case YAML_KEY_TYPE:
+			continue
+		case YAML_KEY_FILEID:
+			co.FileID = v.String()
+		default:
+			if !IsValidProperty(k) {
+				return nil, fmt.Errorf("Invalid property %q for %s %s", k, ct, dbgStr(true))
+			}
+			co.Set(k, v.String())
+		}
+	}
+	return co, nil
+}
+
+func (co *CfgObj) MarshalYAML() (interface{}, error) {
+	return co.yamlProps(true), nil
+}
+
+func (co *CfgObj) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var tmp map[string]yamlValue
+	err := unmarshal(&tmp)
+	if err != nil {
+		return err
+	}
+	t, found := tmp[YAML_KEY_TYPE]
+	if !found {
+		return fmt.Errorf("Missing object type %s", dbgStr(true))
+	}
+	ct := CfgName(t.String()).Type()
+	if ct == T_INVALID {
+		return fmt.Errorf("Invalid object type: %q %s", t, dbgStr(true))
+	}
+	obj, err := cfgObjFromYAML(ct, tmp)
+	if err != nil {
+		return err
+	}
+	*co = *obj
+	return nil
+}
+
+// MarshalYAML groups objects by type (in CfgType order) and name (in the order given by Keys)
+func (cm CfgMap) MarshalYAML() (interface{}, error) {
+	groups := make(map[CfgType]yaml.MapSlice)
+	used := make(map[CfgType]map[string]bool)
+	for _, k := range cm.Keys() {
+		co := cm[k]
+		if used[co.Type] == nil {
+			used[co.Type] = make(map[string]bool)
+		}
+		name, ok := co.Identity()
+		if !ok || used[co.Type][name] {
+			name = k.String()
+		}
+		used[co.Type][name] = true
+		groups[co.Type] = append(groups[co.Type], yaml.MapItem{Key: name, Value: co.yamlProps(false)})
+	}
+
+	out := make(yaml.MapSlice, 0, len(groups))
+	for ct := T_COMMAND; ct < T_INVALID; ct++ {
+		if g, ok := groups[ct]; ok {
+			out = append(out, yaml.MapItem{Key: ct.String(), Value: g})
+		}
+	}
+	return out, nil
+}
+
+// UnmarshalYAML reads objects into the map, registering them in the order they are listed, just like Reader does
+func (cm *CfgMap) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// Values are decoded typed, to keep them as written, and once more generic to find the order of things
+	var tmp map[string]map[string]map[string]yamlValue
+	err := unmarshal(&tmp)
+	if err != nil {
+		return err
+	}
+	var order yaml.MapSlice
+	err = unmarshal(&order)
+	if err != nil {
+		return err
+	}
+
+	if *cm == nil {
+		*cm = make(CfgMap)
+	}
+
+	for _, group := range order {
+		tname := fmt.Sprint(group.Key)
+		ct := CfgName(tname).Type()
+		if ct == T_INVALID {
+			return fmt.Errorf("Invalid object type: %q %s", tname, dbgStr(true))
+		}
+		objs := tmp[tname]
+
+		// names as they come in the document first, then any we failed to match up, so nothing is lost
+		names := make([]string, 0, len(objs))
+		seen := make(map[string]bool, len(objs))
+		if ms, ok := group.Value.(yaml.MapSlice); ok {
+			for _, item := range ms {
+				n := fmt.Sprint(item.Key)
+				if _, found := objs[n]; found && !seen[n] {
+					names = append(names, n)
+					seen[n] = true
+				}
+			}
+		}
+		rest := make([]string, 0)
+		for n := range objs {
+			if !seen[n] {
+				rest = append(rest, n)
+			}
+		}
+		sort.Strings(rest)
+		names = append(names, rest...)
+
+		for _, n := range names {
+			co, err := cfgObjFromYAML(ct, objs[n])
+			if err != nil {
+				return fmt.Errorf("%s %q: %s", tname, n, err)
+			}
+			(*cm)[co.UUID] = co
+			addOrder(co.UUID)
+		}
+	}
+
+	return nil
+}
+
+func (nc *NagiosCfg) MarshalYAML() (interface{}, error) {
+	return yaml.MapSlice{
+		{Key: "sessionid", Value: nc.SessionID.String()},
+		{Key: "version", Value: VERSION},
+		{Key: "cfg", Value: nc.Config},
+	}, nil
+}
+
+// UnmarshalYAML loads a document written by MarshalYAML. Just like for JSON, "version" is ignored,
+// and a document without a session ID gets a new one.
+func (nc *NagiosCfg) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var tmp struct {
+		SessionID string `yaml:"sessionid"`
+		Cfg       CfgMap `yaml:"cfg"`
+	}
+	err := unmarshal(&tmp)
+	if err != nil {
+		return err
+	}
+
+	if tmp.SessionID != "" {
+		nc.SessionID, err = UUIDFromString(tmp.SessionID)
+		if err != nil {
+			return err
+		}
+	} else {
+		nc.SessionID = NewUUIDv1()
+	}
+	if tmp.Cfg == nil {
+		tmp.Cfg = make(CfgMap)
+	}
+	nc.Config = tmp.Cfg
+	nc.pipe = false
+	nc.matches = nil
+
+	return nil
+}
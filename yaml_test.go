@@ -0,0 +1,202 @@
+/*
+   Copyright 2017 Odd Eivind Ebbesen
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nagioscfg
+
+import (
+	"bytes"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var yamlcfgstr string = `define host{
+	name generic-host
+	check_period 24x7
+	max_check_attempts 3
+	register 0
+}
+define host{
+	use generic-host,graphed-host
+	host_name web01
+	address 10.0.0.1
+	_SNMP_COMMUNITY public
+}
+define service{
+	use generic-service
+	host_name web01
+	service_description HTTP
+	check_command check_http!-p 8080!-u /health
+	notification_options n
+	_PORT 8080
+}
+define service{
+	use generic-service
+	host_name web01
+	service_description HTTP
+	check_command check_http!-p 8081
+}
+define hostdependency{
+	host_name web01
+	dependent_host_name web02
+	notification_failure_criteria d,u
+}
+`
+
+// objsByName indexes a CfgMap by type and name (or by property dump, for objects without a usable name)
+func objsByName(t *testing.T, cm CfgMap) map[string]*CfgObj {
+	m := make(map[string]*CfgObj)
+	for _, k := range cm.Keys() {
+		o := cm[k]
+		name, ok := o.Identity()
+		key := o.Type.String() + "/" + name
+		if !ok || m[key] != nil {
+			var buf bytes.Buffer
+			o.PrintPropsSorted(&buf, "%s=%s;")
+			key = o.Type.String() + "/" + buf.String()
+		}
+		m[key] = o
+	}
+	return m
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	cm, err := NewReader(strings.NewReader(yamlcfgstr)).ReadAllMap("/etc/nagios/objects.cfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc1 := NewNagiosCfg()
+	nc1.Config = cm
+
+	ybuf, err := yaml.Marshal(nc1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("\n%s", ybuf)
+
+	nc2 := &NagiosCfg{}
+	err = yaml.Unmarshal(ybuf, nc2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nc1.SessionID.Equals(nc2.SessionID) {
+		t.Errorf("Session ID %s != %s", nc1.SessionID, nc2.SessionID)
+	}
+
+	// and back to Nagios format again
+	var buf bytes.Buffer
+	nc2.Print(&buf, true)
+	cm3, err := NewReader(strings.NewReader(buf.String())).ReadAllMap("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m1 := objsByName(t, nc1.Config)
+	m3 := objsByName(t, cm3)
+	if len(m1) != 5 || len(m1) != len(m3) {
+		t.Fatalf("Expected 5 objects in both, got %d and %d", len(m1), len(m3))
+	}
+	for k, o1 := range m1 {
+		o3, ok := m3[k]
+		if !ok {
+			t.Errorf("Object %q lost in round-trip", k)
+			continue
+		}
+		if o1.Type != o3.Type || !reflect.DeepEqual(o1.Props, o3.Props) {
+			t.Errorf("Objects differ:\n%+v\n%+v", o1.Props, o3.Props)
+		}
+	}
+	for _, k := range nc2.Config.Keys() {
+		if nc2.Config[k].FileID != "/etc/nagios/objects.cfg" {
+			t.Errorf("FileID lost: %q", nc2.Config[k].FileID)
+		}
+	}
+
+	// order within type should be kept
+	hosts := nc2.Config.FilterType(T_HOST)
+	if name, _ := nc2.Config[hosts[0]].GetName(); name != "generic-host" {
+		t.Errorf("Expected template first, got %q", name)
+	}
+}
+
+func TestYAMLUnmarshalHandWritten(t *testing.T) {
+	doc := `
+command:
+  check_http:
+    command_line: $USER1$/check_http -H $HOSTADDRESS$ $ARG1$
+service:
+  web01;HTTP:
+    use:
+      - generic-service
+      - graphed-service
+    host_name: web01
+    service_description: HTTP
+    max_check_attempts: 3
+    notification_options: n
+    _PORT: 8080
+`
+	cm := make(CfgMap)
+	err := yaml.Unmarshal([]byte(doc), &cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm.Len() != 2 {
+		t.Fatalf("Expected 2 objects, got %d", cm.Len())
+	}
+	svc := cm[cm.FilterType(T_SERVICE)[0]]
+	exp := map[string]string{
+		"use":                  "generic-service,graphed-service",
+		"host_name":            "web01",
+		"service_description":  "HTTP",
+		"max_check_attempts":   "3",
+		"notification_options": "n",
+		"_PORT":                "8080",
+	}
+	if !reflect.DeepEqual(svc.Props, exp) {
+		t.Errorf("Expected %v, got %v", exp, svc.Props)
+	}
+
+	bad := "service:\n  x:\n    no_such_key: 1\n"
+	err = yaml.Unmarshal([]byte(bad), &cm)
+	if err == nil {
+		t.Error("Expected error for invalid property")
+	}
+}
+
+func TestCfgObjYAML(t *testing.T) {
+	co := NewCfgObjWithUUID(T_HOST)
+	co.Add("host_name", "db01")
+	co.Add("use", "generic-host,linux")
+	co.Add("_RACK", "B12")
+
+	ybuf, err := yaml.Marshal(co)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(ybuf), "type: host\n") {
+		t.Errorf("Expected type first, got:\n%s", ybuf)
+	}
+
+	co2 := &CfgObj{}
+	err = yaml.Unmarshal(ybuf, co2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if co2.Type != T_HOST || !reflect.DeepEqual(co.Props, co2.Props) {
+		t.Errorf("Objects differ:\n%+v\n%+v", co, co2)
+	}
+}